@@ -3,12 +3,20 @@ package adguardhome
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Client is an interface for the AdguardHome API.
@@ -16,18 +24,44 @@ import (
 type Client interface {
 	GetFilteringRules(ctx context.Context) ([]string, error)
 	SaveFilteringRules(ctx context.Context, rules []string) error
+
+	ListRewrites(ctx context.Context) ([]RewriteEntry, error)
+	AddRewrite(ctx context.Context, entry RewriteEntry) error
+	DeleteRewrite(ctx context.Context, entry RewriteEntry) error
+	UpdateRewrite(ctx context.Context, target, update RewriteEntry) error
+
+	// Health returns an error describing why the instance can't be used, e.g. rejected credentials or disabled filtering
+	Health(ctx context.Context) error
+}
+
+// RewriteEntry is a DNS rewrite configured in AdguardHome.
+// Answer is either an IP address or a domain name, in which case AdguardHome responds with a CNAME.
+type RewriteEntry struct {
+	Domain string `json:"domain"`
+	Answer string `json:"answer"`
 }
 
 type client struct {
 	hc *http.Client
 
 	endpoint string
-	user     string
-	pass     string
+	creds    *credentials
+	retry    retryPolicy
 	dryRun   bool
 }
 
+// retryPolicy configures retries of transient failures of idempotent requests.
+type retryPolicy struct {
+	backoff
+	// maxAttempts includes the first attempt, requests are not retried when it is below 2
+	maxAttempts int
+}
+
+// maxErrorBodySize limits the part of the response body included in errors
+const maxErrorBodySize = 1024
+
 type filteringStatus struct {
+	Enabled   bool     `json:"enabled"`
 	UserRules []string `json:"user_rules"`
 }
 
@@ -35,36 +69,113 @@ type setRules struct {
 	Rules []string `json:"rules"`
 }
 
-func (c *client) doRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+type rewriteUpdate struct {
+	Target RewriteEntry `json:"target"`
+	Update RewriteEntry `json:"update"`
+}
+
+// doRequest sends the request and returns the response if AdguardHome accepted it, otherwise an *APIError is returned.
+// Transient failures of idempotent requests are retried according to the retry policy, as long as the deadline
+// of ctx leaves time for the next attempt.
+func (c *client) doRequest(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := c.doRequestOnce(ctx, method, path, body)
+		if err == nil || attempt >= c.retry.maxAttempts || !idempotent(method, path) {
+			return resp, err
+		}
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || !apiErr.transient() {
+			return nil, err
+		}
+
+		d := c.retry.delay(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= d {
+			log.WithError(err).WithField("attempt", attempt).Warn("request to AdguardHome failed, not retrying past the deadline of the request")
+			return nil, err
+		}
+		log.WithError(err).WithField("attempt", attempt).Warnf("request to AdguardHome failed, retrying in %s", d)
+		apiRetriesTotal.WithLabelValues(path).Inc()
+		if err := sleep(ctx, d); err != nil {
+			return nil, errors.Join(apiErr, err)
+		}
+	}
+}
+
+// idempotent returns true for requests which can be repeated without changing the result.
+// Rewrites are not retried, a repeated request could fail or duplicate the rewrite if the first one was applied.
+func idempotent(method, path string) bool {
+	return method == http.MethodGet || path == "filtering/set_rules"
+}
+
+func (c *client) doRequestOnce(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
 	log.Debugf("making %s request to %s", method, path)
 
-	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+path, body)
+	resp, err := c.send(ctx, method, path, body)
 	if err != nil {
 		return nil, err
 	}
 
-	req.SetBasicAuth(c.user, c.pass)
-	req.Header.Set("Content-Type", "application/json")
+	// Credentials could have been rotated since the files were last read
+	if resp.StatusCode == http.StatusUnauthorized && c.creds.fromFiles() {
+		_ = resp.Body.Close()
 
-	resp, err := c.hc.Do(req)
-	if err != nil {
-		return nil, err
+		changed, err := c.creds.reload()
+		if err != nil {
+			return nil, err
+		}
+		if !changed {
+			return nil, &APIError{Method: method, Path: path, StatusCode: http.StatusUnauthorized}
+		}
+		log.Info("credentials changed, retrying request")
+		resp, err = c.send(ctx, method, path, body)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	log.Debugf("response status code %d", resp.StatusCode)
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		return nil, &APIError{Method: method, Path: path, StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(b))}
 	}
 
 	return resp, nil
 }
 
-func (c *client) status(ctx context.Context) error {
-	if c.dryRun {
-		return nil
+func (c *client) send(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+path, r)
+	if err != nil {
+		return nil, err
 	}
 
+	user, pass, err := c.creds.get()
+	if err != nil {
+		return nil, err
+	}
+
+	req.SetBasicAuth(user, pass)
+	req.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		observeAPIRequest(path, 0, start)
+		return nil, &APIError{Method: method, Path: path, Err: err}
+	}
+	observeAPIRequest(path, resp.StatusCode, start)
+
+	return resp, nil
+}
+
+func (c *client) status(ctx context.Context) error {
 	r, err := c.doRequest(ctx, http.MethodGet, "status", nil)
 	if err != nil {
 		return err
@@ -73,11 +184,35 @@ func (c *client) status(ctx context.Context) error {
 	return nil
 }
 
-func (c *client) GetFilteringRules(ctx context.Context) ([]string, error) {
-	if c.dryRun {
-		return []string{}, nil
+func (c *client) Health(ctx context.Context) error {
+	if err := c.status(ctx); err != nil {
+		return fmt.Errorf("status check failed: %w", err)
 	}
 
+	r, err := c.doRequest(ctx, http.MethodGet, "filtering/status", nil)
+	if err != nil {
+		return fmt.Errorf("filtering status check failed: %w", err)
+	}
+	defer r.Body.Close()
+
+	var resp filteringStatus
+	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to decode filtering status: %w", err)
+	}
+	if !resp.Enabled {
+		return errors.New("filtering is disabled")
+	}
+
+	return nil
+}
+
+func (c *client) GetFilteringRules(ctx context.Context) (rules []string, err error) {
+	ctx, span := startSpan(ctx, "GetFilteringRules")
+	defer func() {
+		span.SetAttributes(attribute.Int("rules.count", len(rules)))
+		endSpan(span, err)
+	}()
+
 	r, err := c.doRequest(ctx, http.MethodGet, "filtering/status", nil)
 	if err != nil {
 		return nil, err
@@ -93,20 +228,74 @@ func (c *client) GetFilteringRules(ctx context.Context) ([]string, error) {
 	return resp.UserRules, nil
 }
 
-func (c *client) SaveFilteringRules(ctx context.Context, rules []string) error {
+func (c *client) SaveFilteringRules(ctx context.Context, rules []string) (err error) {
+	ctx, span := startSpan(ctx, "SaveFilteringRules",
+		attribute.Bool("dry_run", c.dryRun),
+		attribute.Int("rules.count", len(rules)),
+	)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	if c.dryRun {
+		log.Infof("dry run: skipping save of %d filtering rules", len(rules))
+		return nil
+	}
+
+	return c.sendJSON(ctx, http.MethodPost, "filtering/set_rules", setRules{Rules: rules})
+}
+
+func (c *client) ListRewrites(ctx context.Context) ([]RewriteEntry, error) {
+	r, err := c.doRequest(ctx, http.MethodGet, "rewrite/list", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	var resp []RewriteEntry
+	err = json.NewDecoder(r.Body).Decode(&resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (c *client) AddRewrite(ctx context.Context, entry RewriteEntry) error {
+	if c.dryRun {
+		log.Infof("dry run: skipping add of rewrite %s -> %s", entry.Domain, entry.Answer)
+		return nil
+	}
+
+	return c.sendJSON(ctx, http.MethodPost, "rewrite/add", entry)
+}
+
+func (c *client) DeleteRewrite(ctx context.Context, entry RewriteEntry) error {
 	if c.dryRun {
+		log.Infof("dry run: skipping delete of rewrite %s -> %s", entry.Domain, entry.Answer)
 		return nil
 	}
 
-	body := setRules{Rules: rules}
+	return c.sendJSON(ctx, http.MethodPost, "rewrite/delete", entry)
+}
+
+func (c *client) UpdateRewrite(ctx context.Context, target, update RewriteEntry) error {
+	if c.dryRun {
+		log.Infof("dry run: skipping update of rewrite %s -> %s to %s -> %s", target.Domain, target.Answer, update.Domain, update.Answer)
+		return nil
+	}
 
-	b := bytes.NewBuffer(nil)
-	err := json.NewEncoder(b).Encode(body)
+	return c.sendJSON(ctx, http.MethodPut, "rewrite/update", rewriteUpdate{Target: target, Update: update})
+}
+
+// sendJSON sends body encoded as JSON and discards the response.
+func (c *client) sendJSON(ctx context.Context, method, path string, body any) error {
+	b, err := json.Marshal(body)
 	if err != nil {
 		return err
 	}
 
-	r, err := c.doRequest(ctx, http.MethodPost, "filtering/set_rules", b)
+	r, err := c.doRequest(ctx, method, path, b)
 	if err != nil {
 		return err
 	}
@@ -114,21 +303,67 @@ func (c *client) SaveFilteringRules(ctx context.Context, rules []string) error {
 	return nil
 }
 
-func newAdguardHomeClient(endpoint, user, pass string, dryRun bool) (*client, error) {
-	hc := http.Client{}
-	c := &client{
-		hc:       &hc,
-		endpoint: endpoint,
-		user:     user,
-		pass:     pass,
+// newHTTPClient returns an HTTP client configured according to the timeout, proxy and TLS settings.
+func newHTTPClient(cfg *Config) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
 
-		dryRun: dryRun,
+	if cfg.ProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
 	}
 
-	err := c.status(context.Background())
-	if err != nil {
-		return nil, err
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
 	}
 
-	return c, nil
+	if cfg.TLS.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(cfg.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", cfg.TLS.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.TLS.InsecureSkipVerify {
+		log.Warn("TLS certificate verification of AdguardHome is DISABLED, connections are vulnerable to man-in-the-middle attacks. " +
+			"Configure a CA file instead of skipping verification whenever possible.")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Timeout:   cfg.Timeout.Duration,
+		Transport: transport,
+	}, nil
+}
+
+// newAdguardHomeClient returns a client of the AdguardHome API at endpoint, the connection is verified by the provider.
+func newAdguardHomeClient(endpoint string, hc *http.Client, creds *credentials, retry retryPolicy, dryRun bool) *client {
+	return &client{
+		hc:       hc,
+		endpoint: endpoint,
+		creds:    creds,
+		retry:    retry,
+
+		dryRun: dryRun,
+	}
 }
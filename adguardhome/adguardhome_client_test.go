@@ -0,0 +1,210 @@
+package adguardhome
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T, user, pass *string) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok || u != *user || p != *pass {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/control/filtering/status":
+			_, _ = w.Write([]byte(`{"enabled": true, "user_rules": ["1.1.1.1 example.com #$managed by external-dns"]}`))
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestClient_CredentialsRotation(t *testing.T) {
+	user, pass := "admin", "old"
+	srv := newTestServer(t, &user, &pass)
+
+	dir := t.TempDir()
+	passFile := filepath.Join(dir, "password")
+	if err := os.WriteFile(passFile, []byte("old\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := newCredentials("admin", "", "", passFile)
+	if err != nil {
+		t.Fatalf("newCredentials() error = %v", err)
+	}
+	c := newAdguardHomeClient(srv.URL+"/control/", srv.Client(), creds, retryPolicy{}, false)
+
+	// Rotate the password keeping the modification time, so the change is only noticed after a 401
+	st, err := os.Stat(passFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pass = "new"
+	if err := os.WriteFile(passFile, []byte("new\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(passFile, st.ModTime(), st.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := c.GetFilteringRules(context.Background())
+	if err != nil {
+		t.Fatalf("GetFilteringRules() error = %v", err)
+	}
+	if len(rules) != 1 {
+		t.Errorf("expected 1 rule, got %v", rules)
+	}
+
+	// A modified file is picked up before the request is sent
+	pass = "newer"
+	if err := os.WriteFile(passFile, []byte("newer"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := st.ModTime().Add(time.Minute)
+	if err := os.Chtimes(passFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.GetFilteringRules(context.Background()); err != nil {
+		t.Fatalf("GetFilteringRules() error = %v", err)
+	}
+
+	// Wrong credentials are still reported
+	pass = "unknown"
+	if _, err := c.GetFilteringRules(context.Background()); err == nil {
+		t.Errorf("expected error for invalid credentials")
+	}
+}
+
+func TestNewHTTPClient_TLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		tls     ClientTLSConfig
+		wantErr bool
+	}{
+		{name: "untrusted certificate", wantErr: true},
+		{name: "custom CA", tls: ClientTLSConfig{CAFile: caFile}},
+		{name: "insecure skip verify", tls: ClientTLSConfig{InsecureSkipVerify: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.TLS = tt.tls
+
+			hc, err := newHTTPClient(cfg)
+			if err != nil {
+				t.Fatalf("newHTTPClient() error = %v", err)
+			}
+
+			resp, err := hc.Get(srv.URL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+		})
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	var failures, adds atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/control/filtering/status":
+			if failures.Add(-1) >= 0 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			_, _ = w.Write([]byte(`{"enabled": true, "user_rules": []}`))
+		case "/control/rewrite/add":
+			adds.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/control/rewrite/delete":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("rewrite not found\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	retry := retryPolicy{backoff: backoff{initial: time.Millisecond, max: time.Millisecond, factor: 2, jitter: true}, maxAttempts: 3}
+	c := newAdguardHomeClient(srv.URL+"/control/", srv.Client(), &credentials{}, retry, false)
+	ctx := context.Background()
+
+	failures.Store(2)
+	if _, err := c.GetFilteringRules(ctx); err != nil {
+		t.Errorf("expected transient failures to be retried, got %v", err)
+	}
+
+	failures.Store(3)
+	_, err := c.GetFilteringRules(ctx)
+	if !errors.Is(err, ErrServer) {
+		t.Errorf("expected server error after exhausting retries, got %v", err)
+	}
+
+	// Retries must not outlive the request, e.g. the webhook call of ExternalDNS
+	c.retry.initial, c.retry.max = time.Minute, time.Minute
+	failures.Store(1)
+	deadlineCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.GetFilteringRules(deadlineCtx); !errors.Is(err, ErrServer) {
+		t.Errorf("expected server error without retrying past the deadline, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected no retry past the deadline, took %s", elapsed)
+	}
+	c.retry.initial, c.retry.max = time.Millisecond, time.Millisecond
+
+	var apiErr *APIError
+	err = c.AddRewrite(ctx, RewriteEntry{Domain: "example.com", Answer: "1.1.1.1"})
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected API error of the rewrite, got %v", err)
+	}
+	if adds.Load() != 1 {
+		t.Errorf("expected rewrites not to be retried, got %d attempts", adds.Load())
+	}
+
+	err = c.DeleteRewrite(ctx, RewriteEntry{Domain: "example.com", Answer: "1.1.1.1"})
+	if err == nil || err.Error() != "POST rewrite/delete: unexpected status code 400: rewrite not found" {
+		t.Errorf("expected response body in the error, got %v", err)
+	}
+
+	if _, err := c.ListRewrites(ctx); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+
+	srv.Close()
+	if _, err := c.ListRewrites(ctx); !errors.Is(err, ErrNetwork) {
+		t.Errorf("expected network error, got %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package adguardhome
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// backoff computes exponentially growing delays between retries.
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+	factor  float64
+	// jitter randomizes delays between half and the full value, so clients failing together don't retry together
+	jitter bool
+}
+
+// delay returns the delay before the retry following the given number of failed attempts, starting with 1.
+func (b backoff) delay(attempt int) time.Duration {
+	d := float64(b.initial)
+	for i := 1; i < attempt && d < float64(b.max); i++ {
+		d *= b.factor
+	}
+	d = min(d, float64(b.max))
+
+	if b.jitter {
+		d = d/2 + rand.Float64()*d/2
+	}
+	return time.Duration(d)
+}
+
+// retry calls fn until it succeeds or ctx is done, waiting according to b between attempts.
+// onError is called with every failure and the delay before the next attempt.
+func (b backoff) retry(ctx context.Context, fn func(context.Context) error, onError func(err error, attempt int, delay time.Duration)) error {
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+
+		d := b.delay(attempt)
+		onError(err, attempt, d)
+
+		if err := sleep(ctx, d); err != nil {
+			return err
+		}
+	}
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
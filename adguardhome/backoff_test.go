@@ -0,0 +1,55 @@
+package adguardhome
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff_Delay(t *testing.T) {
+	b := backoff{initial: time.Second, max: 10 * time.Second, factor: 2}
+
+	for attempt, expected := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		4:  8 * time.Second,
+		5:  10 * time.Second,
+		50: 10 * time.Second,
+	} {
+		if got := b.delay(attempt); got != expected {
+			t.Errorf("delay(%d) = %s, want %s", attempt, got, expected)
+		}
+	}
+}
+
+func TestBackoff_Retry(t *testing.T) {
+	b := backoff{initial: time.Millisecond, max: time.Millisecond, factor: 2}
+
+	calls := 0
+	failures := 0
+	err := b.retry(context.Background(), func(context.Context) error {
+		calls++
+		if calls < 3 {
+			return errors.New("unreachable")
+		}
+		return nil
+	}, func(error, int, time.Duration) {
+		failures++
+	})
+	if err != nil {
+		t.Fatalf("retry() error = %v", err)
+	}
+	if calls != 3 || failures != 2 {
+		t.Errorf("expected 3 calls and 2 failures, got %d and %d", calls, failures)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = b.retry(ctx, func(context.Context) error {
+		return errors.New("unreachable")
+	}, func(error, int, time.Duration) {})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context error, got %v", err)
+	}
+}
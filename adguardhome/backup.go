@@ -0,0 +1,157 @@
+package adguardhome
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	backupPrefix = "rules-"
+	backupSuffix = ".txt"
+	// backupTimeFormat sorts lexicographically in chronological order
+	backupTimeFormat = "20060102T150405.000000000Z"
+)
+
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// backups stores snapshots of filtering rules in a directory per instance.
+type backups struct {
+	dir string
+	// retention is the number of snapshots kept per instance
+	retention int
+	now       func() time.Time
+}
+
+func newBackups(cfg BackupConfig) *backups {
+	if cfg.Dir == "" {
+		return nil
+	}
+
+	return &backups{
+		dir:       cfg.Dir,
+		retention: cfg.Retention,
+		now:       time.Now,
+	}
+}
+
+// instanceDir returns the directory holding snapshots of the named instance
+func instanceDir(dir, instance string) string {
+	return filepath.Join(dir, unsafePathChars.ReplaceAllString(instance, "_"))
+}
+
+// save writes a snapshot of rules of the instance and removes snapshots exceeding the retention.
+func (b *backups) save(instance string, rules []string) (string, error) {
+	dir := instanceDir(b.dir, instance)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	path := filepath.Join(dir, backupPrefix+b.now().UTC().Format(backupTimeFormat)+backupSuffix)
+	data := strings.Join(rules, "\n")
+	if len(rules) > 0 {
+		data += "\n"
+	}
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		return "", fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	snapshots, err := ListBackups(b.dir, instance)
+	if err != nil {
+		return path, err
+	}
+	if len(snapshots) > b.retention {
+		for _, old := range snapshots[:len(snapshots)-b.retention] {
+			if err := os.Remove(old); err != nil {
+				log.WithError(err).Warnf("failed to remove old backup %s", old)
+			}
+		}
+	}
+
+	return path, nil
+}
+
+// backup stores a snapshot of the current rules of the instance unless they are about to be saved unchanged.
+func (p *AdguardHomeProvider) backup(instance string, current, resulting []string) error {
+	if p.backups == nil || slices.Equal(current, resulting) {
+		return nil
+	}
+
+	path, err := p.backups.save(instance, current)
+	if err != nil {
+		return fmt.Errorf("refusing to save rules without a backup: %w", err)
+	}
+	log.WithField("instance", instance).Debugf("backed up filtering rules to %s", path)
+
+	return nil
+}
+
+// RestoreRules replaces filtering rules of the named instance with rules, e.g. read from a backup by ReadBackup.
+// The current rules are backed up first, so a restore can be reverted. In dry run mode the difference
+// is logged instead and nothing is backed up or saved.
+func (p *AdguardHomeProvider) RestoreRules(ctx context.Context, name string, rules []string) error {
+	for _, inst := range p.instances() {
+		if inst.name != name {
+			continue
+		}
+
+		current, err := inst.client.GetFilteringRules(ctx)
+		if err != nil {
+			return err
+		}
+		if p.dryRun {
+			p.logDryRunDiff(inst.name, current, rules)
+			return nil
+		}
+		if err := p.backup(inst.name, current, rules); err != nil {
+			return err
+		}
+		return inst.client.SaveFilteringRules(ctx, rules)
+	}
+
+	return fmt.Errorf("unknown instance %q", name)
+}
+
+// ListBackups returns paths of snapshots of the named instance stored in dir, the oldest first.
+func ListBackups(dir, instance string) ([]string, error) {
+	entries, err := os.ReadDir(instanceDir(dir, instance))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var ret []string
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), backupPrefix) || !strings.HasSuffix(e.Name(), backupSuffix) {
+			continue
+		}
+		ret = append(ret, filepath.Join(instanceDir(dir, instance), e.Name()))
+	}
+	slices.Sort(ret)
+
+	return ret, nil
+}
+
+// ReadBackup returns the filtering rules stored in the snapshot at path.
+func ReadBackup(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := strings.TrimSuffix(string(data), "\n")
+	if s == "" {
+		return []string{}, nil
+	}
+	return strings.Split(s, "\n"), nil
+}
@@ -0,0 +1,119 @@
+package adguardhome
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func TestBackups_Retention(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	b := &backups{dir: dir, retention: 2, now: func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}}
+
+	rules := [][]string{{"a"}, {"b", "", "c"}, {}}
+	for _, r := range rules {
+		if _, err := b.save("adguard.home:3000", r); err != nil {
+			t.Fatalf("save() error = %v", err)
+		}
+	}
+
+	snapshots, err := ListBackups(dir, "adguard.home:3000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		filepath.Join(dir, "adguard.home_3000", "rules-20240102T030407.000000000Z.txt"),
+		filepath.Join(dir, "adguard.home_3000", "rules-20240102T030408.000000000Z.txt"),
+	}
+	if !reflect.DeepEqual(snapshots, expected) {
+		t.Fatalf("ListBackups() = %v, want %v", snapshots, expected)
+	}
+
+	for i, s := range snapshots {
+		got, err := ReadBackup(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, rules[i+1]) {
+			t.Errorf("ReadBackup(%s) = %q, want %q", s, got, rules[i+1])
+		}
+	}
+}
+
+func TestAdguardHomeProvider_Backups(t *testing.T) {
+	dir := t.TempDir()
+	c := newMockClient()
+	original := c.rules
+	p := &AdguardHomeProvider{
+		client:  c,
+		backups: &backups{dir: dir, retention: 10, now: time.Now},
+	}
+
+	// The artificial rule of example.com is missing from the original rules, so the first sync changes them
+	err := p.ApplyChanges(context.Background(), &plan.Changes{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshots, _ := ListBackups(dir, "primary"); len(snapshots) != 1 {
+		t.Fatalf("expected 1 snapshot, got %v", snapshots)
+	}
+
+	err = p.ApplyChanges(context.Background(), &plan.Changes{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshots, _ := ListBackups(dir, "primary"); len(snapshots) != 1 {
+		t.Fatalf("expected no snapshot of a save without changes, got %v", snapshots)
+	}
+
+	err = p.ApplyChanges(context.Background(), &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			{DNSName: "new.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"2.2.2.2"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshots, _ := ListBackups(dir, "primary")
+	if len(snapshots) != 2 {
+		t.Fatalf("expected 2 snapshots, got %v", snapshots)
+	}
+
+	// Dry runs neither back up nor save the rules
+	p.dryRun = true
+	current := slices.Clone(c.rules)
+	if err := p.RestoreRules(context.Background(), "primary", original); err != nil {
+		t.Fatalf("RestoreRules() error = %v", err)
+	}
+	if !reflect.DeepEqual(c.rules, current) {
+		t.Errorf("expected rules not to be restored in dry run, got %v", c.rules)
+	}
+	if snapshots, _ := ListBackups(dir, "primary"); len(snapshots) != 2 {
+		t.Errorf("expected no backup in dry run, got %v", snapshots)
+	}
+	p.dryRun = false
+
+	if err := p.RestoreRules(context.Background(), "primary", original); err != nil {
+		t.Fatalf("RestoreRules() error = %v", err)
+	}
+	if !reflect.DeepEqual(c.rules, original) {
+		t.Errorf("expected original rules to be restored, got %v", c.rules)
+	}
+	if snapshots, _ := ListBackups(dir, "primary"); len(snapshots) != 3 {
+		t.Errorf("expected rules to be backed up before restoring, got %v", snapshots)
+	}
+
+	if err := p.RestoreRules(context.Background(), "secondary", original); err == nil {
+		t.Error("expected error for unknown instance")
+	}
+}
@@ -0,0 +1,563 @@
+package adguardhome
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	envURL          = "ADGUARD_HOME_URL"
+	envPassword     = "ADGUARD_HOME_PASS"
+	envUser         = "ADGUARD_HOME_USER"
+	envUserFile     = "ADGUARD_HOME_USER_FILE"
+	envPasswordFile = "ADGUARD_HOME_PASS_FILE"
+	envManagedBy    = "ADGUARD_HOME_MANAGED_BY_REF"
+	envBackend      = "ADGUARD_HOME_BACKEND"
+	envRecordTypes  = "ADGUARD_HOME_RECORD_TYPES"
+	envDryRun       = "ADGUARD_HOME_DRY_RUN"
+	envDryRunDiff   = "ADGUARD_HOME_DRY_RUN_DIFF"
+	envReplicaURLs  = "ADGUARD_HOME_REPLICA_URLS"
+	envRecordsFrom  = "ADGUARD_HOME_RECORDS_SOURCE"
+	envListenAddr   = "ADGUARD_HOME_LISTEN_ADDRESS"
+	envReadTimeout  = "ADGUARD_HOME_READ_TIMEOUT"
+	envWriteTimeout = "ADGUARD_HOME_WRITE_TIMEOUT"
+	envHealthCheck  = "ADGUARD_HOME_HEALTH_CHECK_INTERVAL"
+	envStartupMode  = "ADGUARD_HOME_STARTUP_MODE"
+	envConflicts    = "ADGUARD_HOME_CONFLICT_RETRIES"
+	envMarker       = "ADGUARD_HOME_MARKER_VERSION"
+
+	envBackupDir       = "ADGUARD_HOME_BACKUP_DIR"
+	envBackupRetention = "ADGUARD_HOME_BACKUP_RETENTION"
+
+	envMaxDeletions       = "ADGUARD_HOME_MAX_DELETIONS"
+	envMaxDeletionPercent = "ADGUARD_HOME_MAX_DELETION_PERCENT"
+	envAllowMassDeletion  = "ADGUARD_HOME_ALLOW_MASS_DELETION"
+
+	envTimeout            = "ADGUARD_HOME_TIMEOUT"
+	envProxyURL           = "ADGUARD_HOME_PROXY_URL"
+	envCAFile             = "ADGUARD_HOME_CA_FILE"
+	envClientCertFile     = "ADGUARD_HOME_CLIENT_CERT_FILE"
+	envClientKeyFile      = "ADGUARD_HOME_CLIENT_KEY_FILE"
+	envInsecureSkipVerify = "ADGUARD_HOME_INSECURE_SKIP_VERIFY"
+
+	envRetryMaxAttempts     = "ADGUARD_HOME_RETRY_MAX_ATTEMPTS"
+	envRetryInitialInterval = "ADGUARD_HOME_RETRY_INITIAL_INTERVAL"
+	envRetryMaxInterval     = "ADGUARD_HOME_RETRY_MAX_INTERVAL"
+
+	envTLSCertFile     = "ADGUARD_HOME_TLS_CERT_FILE"
+	envTLSKeyFile      = "ADGUARD_HOME_TLS_KEY_FILE"
+	envTLSClientCAFile = "ADGUARD_HOME_TLS_CLIENT_CA_FILE"
+
+	envDomainFilter         = "ADGUARD_HOME_DOMAIN_FILTER"
+	envExcludeDomains       = "ADGUARD_HOME_EXCLUDE_DOMAINS"
+	envRegexDomainFilter    = "ADGUARD_HOME_REGEX_DOMAIN_FILTER"
+	envRegexDomainExclusion = "ADGUARD_HOME_REGEX_DOMAIN_EXCLUSION"
+)
+
+// Config holds the configuration of the provider binary.
+// Values are read from the configuration file, environment variables override the file and
+// command line flags override both.
+type Config struct {
+	// URL of the AdguardHome instance, e.g. http://adguard.home:3000
+	URL      string `json:"url"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	// UserFile and PasswordFile are read instead of User and Password, the files are re-read when they change
+	UserFile     string `json:"userFile"`
+	PasswordFile string `json:"passwordFile"`
+	// Timeout of a single request to the AdguardHome API, it should be well below Server.WriteTimeout
+	// to leave time for retries of the webhook call
+	Timeout Duration `json:"timeout"`
+	// ProxyURL of the HTTP proxy used to reach AdguardHome, proxy environment variables are used when empty
+	ProxyURL string          `json:"proxyURL"`
+	TLS      ClientTLSConfig `json:"tls"`
+	Retry    RetryConfig     `json:"retry"`
+	// ManagedByRef allows running multiple providers against a single AdguardHome instance
+	ManagedByRef string `json:"managedByRef"`
+	// MarkerVersion is the version of the ownership marker written to managed rules, rules are read in every version
+	MarkerVersion int `json:"markerVersion"`
+	// Backend is either "rules" or "rewrites"
+	Backend string `json:"backend"`
+	// DryRun reads AdguardHome and logs changes instead of applying them
+	DryRun bool `json:"dryRun"`
+	// DryRunDiff is either "managed" to log changes of managed rules only or "full" to include all rules
+	DryRunDiff string `json:"dryRunDiff"`
+	// RecordTypes limits record types managed by the provider, all supported types are managed when empty
+	RecordTypes  []string            `json:"recordTypes"`
+	DomainFilter DomainFilterOptions `json:"domainFilter"`
+	Server       ServerConfig        `json:"server"`
+	// Replicas are additional AdguardHome instances receiving every change applied to URL
+	Replicas []InstanceConfig `json:"replicas"`
+	// RecordsSource is either "primary" to read records from URL only or "union" to merge records of all instances
+	RecordsSource string `json:"recordsSource"`
+	// HealthCheckInterval is the interval of AdguardHome health checks reported by the readiness endpoint
+	HealthCheckInterval Duration `json:"healthCheckInterval"`
+	// StartupMode is either "fail" to exit when AdguardHome is unreachable on startup or "background"
+	// to keep retrying while serving the webhook
+	StartupMode string `json:"startupMode"`
+	// ConflictRetries limits merging changes again when filtering rules are changed concurrently, e.g. in the AdguardHome UI
+	ConflictRetries int                 `json:"conflictRetries"`
+	Backup          BackupConfig        `json:"backup"`
+	DeletionGuard   DeletionGuardConfig `json:"deletionGuard"`
+}
+
+// DeletionGuardConfig limits deletions of managed records in a single batch of changes,
+// so a misconfigured source of external-dns stalls the sync instead of wiping DNS.
+type DeletionGuardConfig struct {
+	// MaxDeletions is the number of managed records a batch may delete per instance, 0 disables the limit
+	MaxDeletions int `json:"maxDeletions"`
+	// MaxDeletionPercent is the percentage of managed records a batch may delete per instance, 0 disables the limit
+	MaxDeletionPercent int `json:"maxDeletionPercent"`
+	// AllowMassDeletion applies batches exceeding the limits with a warning instead of refusing them
+	AllowMassDeletion bool `json:"allowMassDeletion"`
+}
+
+// BackupConfig configures snapshots of filtering rules taken before they are changed.
+type BackupConfig struct {
+	// Dir stores snapshots in a subdirectory per instance, backups are disabled when empty
+	Dir string `json:"dir"`
+	// Retention is the number of snapshots kept per instance
+	Retention int `json:"retention"`
+}
+
+// InstanceConfig configures an additional AdguardHome instance.
+// Credentials of the primary instance are used when none are set.
+type InstanceConfig struct {
+	// Name identifies the instance in logs and errors, the host of URL is used when empty
+	Name         string `json:"name"`
+	URL          string `json:"url"`
+	User         string `json:"user"`
+	Password     string `json:"password"`
+	UserFile     string `json:"userFile"`
+	PasswordFile string `json:"passwordFile"`
+}
+
+// ClientTLSConfig configures TLS of connections to the AdguardHome API.
+type ClientTLSConfig struct {
+	// CAFile is a CA bundle trusted in addition to system CAs, e.g. for self-signed AdguardHome certificates
+	CAFile string `json:"caFile"`
+	// CertFile and KeyFile are the client certificate presented to AdguardHome
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// InsecureSkipVerify disables verification of the AdguardHome certificate
+	InsecureSkipVerify bool `json:"insecureSkipVerify"`
+}
+
+// RetryConfig configures retries of transient AdguardHome API failures: network errors, 5xx and 429 responses.
+// Only requests which can be safely repeated are retried, rewrite changes are retried by the next sync instead.
+type RetryConfig struct {
+	// MaxAttempts includes the first attempt, 1 disables retries
+	MaxAttempts int `json:"maxAttempts"`
+	// InitialInterval is doubled after every attempt up to MaxInterval, a random jitter of up to half of the interval is subtracted
+	InitialInterval Duration `json:"initialInterval"`
+	MaxInterval     Duration `json:"maxInterval"`
+}
+
+func (r RetryConfig) policy() retryPolicy {
+	return retryPolicy{
+		backoff: backoff{
+			initial: r.InitialInterval.Duration,
+			max:     r.MaxInterval.Duration,
+			factor:  2,
+			jitter:  true,
+		},
+		maxAttempts: r.MaxAttempts,
+	}
+}
+
+// DomainFilterOptions configures domains managed by the provider.
+type DomainFilterOptions struct {
+	// Include limits the provider to the listed domains and their subdomains
+	Include []string `json:"include"`
+	// Exclude lists domains and their subdomains which are never managed
+	Exclude []string `json:"exclude"`
+	// Regex limits the provider to domains matching the expression, it can't be combined with lists
+	Regex string `json:"regex"`
+	// RegexExclusion excludes domains matching the expression, it can't be combined with lists
+	RegexExclusion string `json:"regexExclusion"`
+}
+
+// ServerConfig configures the webhook server.
+type ServerConfig struct {
+	ListenAddress string   `json:"listenAddress"`
+	ReadTimeout   Duration `json:"readTimeout"`
+	// WriteTimeout also bounds the AdguardHome requests of a webhook call including their retries
+	WriteTimeout Duration `json:"writeTimeout"`
+	// TLS is enabled when a certificate is configured
+	TLS ServerTLSConfig `json:"tls"`
+}
+
+// ServerTLSConfig configures TLS of the webhook server.
+type ServerTLSConfig struct {
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// ClientCAFile enables mutual TLS, clients of the webhook API must present a certificate signed by one of the CAs.
+	// Health checks and metrics are served without a client certificate.
+	ClientCAFile string `json:"clientCAFile"`
+}
+
+// Duration is a time.Duration represented as a string such as "10s" in configuration files.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"10s\": %w", err)
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// DefaultConfig returns the configuration used for values which are not set explicitly.
+func DefaultConfig() *Config {
+	return &Config{
+		Backend:       backendRules,
+		MarkerVersion: markerV2,
+		RecordsSource: recordsSourcePrimary,
+		DryRunDiff:    dryRunDiffManaged,
+		Timeout:       Duration{5 * time.Second},
+		Retry: RetryConfig{
+			MaxAttempts:     3,
+			InitialInterval: Duration{500 * time.Millisecond},
+			MaxInterval:     Duration{5 * time.Second},
+		},
+		HealthCheckInterval: Duration{30 * time.Second},
+		StartupMode:         startupModeFail,
+		ConflictRetries:     3,
+		Backup:              BackupConfig{Retention: 10},
+		Server: ServerConfig{
+			ListenAddress: ":8888",
+			ReadTimeout:   Duration{10 * time.Second},
+			WriteTimeout:  Duration{10 * time.Second},
+		},
+	}
+}
+
+// LoadConfig reads the YAML or JSON configuration file at path and applies environment variable overrides.
+// Only defaults and environment variables are used when path is empty.
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) applyEnv() error {
+	lookupString(envURL, &c.URL)
+	lookupString(envUser, &c.User)
+	lookupString(envPassword, &c.Password)
+	lookupString(envUserFile, &c.UserFile)
+	lookupString(envPasswordFile, &c.PasswordFile)
+	lookupString(envManagedBy, &c.ManagedByRef)
+	lookupString(envBackend, &c.Backend)
+	lookupList(envRecordTypes, &c.RecordTypes)
+	lookupString(envRecordsFrom, &c.RecordsSource)
+	lookupString(envStartupMode, &c.StartupMode)
+	lookupString(envDryRunDiff, &c.DryRunDiff)
+	lookupString(envBackupDir, &c.Backup.Dir)
+	if s, ok := os.LookupEnv(envReplicaURLs); ok {
+		c.Replicas = nil
+		for _, u := range SplitList(s) {
+			c.Replicas = append(c.Replicas, InstanceConfig{URL: u})
+		}
+	}
+
+	lookupList(envDomainFilter, &c.DomainFilter.Include)
+	lookupList(envExcludeDomains, &c.DomainFilter.Exclude)
+	lookupString(envRegexDomainFilter, &c.DomainFilter.Regex)
+	lookupString(envRegexDomainExclusion, &c.DomainFilter.RegexExclusion)
+
+	lookupString(envListenAddr, &c.Server.ListenAddress)
+	lookupString(envTLSCertFile, &c.Server.TLS.CertFile)
+	lookupString(envTLSKeyFile, &c.Server.TLS.KeyFile)
+	lookupString(envTLSClientCAFile, &c.Server.TLS.ClientCAFile)
+
+	lookupString(envProxyURL, &c.ProxyURL)
+	lookupString(envCAFile, &c.TLS.CAFile)
+	lookupString(envClientCertFile, &c.TLS.CertFile)
+	lookupString(envClientKeyFile, &c.TLS.KeyFile)
+
+	return errors.Join(
+		lookupBool(envDryRun, &c.DryRun),
+		lookupBool(envAllowMassDeletion, &c.DeletionGuard.AllowMassDeletion),
+		lookupBool(envInsecureSkipVerify, &c.TLS.InsecureSkipVerify),
+		lookupInt(envRetryMaxAttempts, &c.Retry.MaxAttempts),
+		lookupInt(envConflicts, &c.ConflictRetries),
+		lookupInt(envMarker, &c.MarkerVersion),
+		lookupInt(envBackupRetention, &c.Backup.Retention),
+		lookupInt(envMaxDeletions, &c.DeletionGuard.MaxDeletions),
+		lookupInt(envMaxDeletionPercent, &c.DeletionGuard.MaxDeletionPercent),
+		lookupDuration(envRetryInitialInterval, &c.Retry.InitialInterval),
+		lookupDuration(envRetryMaxInterval, &c.Retry.MaxInterval),
+		lookupDuration(envReadTimeout, &c.Server.ReadTimeout),
+		lookupDuration(envWriteTimeout, &c.Server.WriteTimeout),
+		lookupDuration(envTimeout, &c.Timeout),
+		lookupDuration(envHealthCheck, &c.HealthCheckInterval),
+	)
+}
+
+func lookupString(name string, v *string) {
+	if s, ok := os.LookupEnv(name); ok {
+		*v = s
+	}
+}
+
+func lookupBool(name string, v *bool) error {
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("invalid boolean in environment variable %s: %w", name, err)
+	}
+	*v = b
+	return nil
+}
+
+func lookupDuration(name string, v *Duration) error {
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration in environment variable %s: %w", name, err)
+	}
+	v.Duration = d
+	return nil
+}
+
+func lookupInt(name string, v *int) error {
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("invalid number in environment variable %s: %w", name, err)
+	}
+	*v = i
+	return nil
+}
+
+func lookupList(name string, v *[]string) {
+	if s, ok := os.LookupEnv(name); ok {
+		*v = SplitList(s)
+	}
+}
+
+// SplitList returns non-empty comma separated values of s
+func SplitList(s string) []string {
+	var ret []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// Validate checks the configuration and reports every problem found.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.URL == "" {
+		errs = append(errs, fmt.Errorf("url is required, set it in the config file or environment variable %s", envURL))
+	} else {
+		errs = append(errs, validateInstanceURL(c.URL))
+	}
+	names := map[string]struct{}{c.primaryInstance().Name: {}}
+	for i, replica := range c.replicaInstances() {
+		if replica.URL == "" {
+			errs = append(errs, fmt.Errorf("url of replica %d is required", i))
+			continue
+		}
+		errs = append(errs, validateInstanceURL(replica.URL))
+		if _, ok := names[replica.Name]; ok {
+			errs = append(errs, fmt.Errorf("duplicate instance name %q", replica.Name))
+		}
+		names[replica.Name] = struct{}{}
+		if replica.User != "" && replica.UserFile != "" || replica.Password != "" && replica.PasswordFile != "" {
+			errs = append(errs, fmt.Errorf("credentials of replica %s can't be set both as values and files", replica.Name))
+		}
+	}
+	if c.RecordsSource != recordsSourcePrimary && c.RecordsSource != recordsSourceUnion {
+		errs = append(errs, fmt.Errorf("unsupported records source %q, expected %q or %q", c.RecordsSource, recordsSourcePrimary, recordsSourceUnion))
+	}
+	if c.Timeout.Duration <= 0 {
+		errs = append(errs, errors.New("timeout must be positive"))
+	}
+	if c.DryRunDiff != dryRunDiffManaged && c.DryRunDiff != dryRunDiffFull {
+		errs = append(errs, fmt.Errorf("unsupported dry run diff %q, expected %q or %q", c.DryRunDiff, dryRunDiffManaged, dryRunDiffFull))
+	}
+	if c.StartupMode != startupModeFail && c.StartupMode != startupModeBackground {
+		errs = append(errs, fmt.Errorf("unsupported startup mode %q, expected %q or %q", c.StartupMode, startupModeFail, startupModeBackground))
+	}
+	if c.ConflictRetries < 0 {
+		errs = append(errs, errors.New("conflict retries must not be negative"))
+	}
+	if c.Backup.Retention < 1 {
+		errs = append(errs, errors.New("backup retention must be at least 1"))
+	}
+	if c.DeletionGuard.MaxDeletions < 0 {
+		errs = append(errs, errors.New("max deletions must not be negative"))
+	}
+	if c.DeletionGuard.MaxDeletionPercent < 0 || c.DeletionGuard.MaxDeletionPercent > 100 {
+		errs = append(errs, errors.New("max deletion percent must be between 0 and 100"))
+	}
+	if c.HealthCheckInterval.Duration <= 0 {
+		errs = append(errs, errors.New("health check interval must be positive"))
+	}
+	if c.Retry.MaxAttempts < 1 {
+		errs = append(errs, errors.New("retry max attempts must be at least 1"))
+	}
+	if c.Retry.InitialInterval.Duration <= 0 || c.Retry.MaxInterval.Duration < c.Retry.InitialInterval.Duration {
+		errs = append(errs, errors.New("retry initial interval must be positive and not exceed the max interval"))
+	}
+	if c.ProxyURL != "" {
+		if u, err := url.Parse(c.ProxyURL); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("proxy url %q must be an absolute URL", c.ProxyURL))
+		}
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("client certificate and key files must be set together"))
+	}
+	errs = append(errs, validateSecret("user", c.User, envUser, c.UserFile, envUserFile))
+	errs = append(errs, validateSecret("password", c.Password, envPassword, c.PasswordFile, envPasswordFile))
+
+	errs = append(errs, validateRef(c.ManagedByRef))
+	if c.MarkerVersion != markerV1 && c.MarkerVersion != markerV2 {
+		errs = append(errs, fmt.Errorf("unsupported marker version %d, expected %d or %d", c.MarkerVersion, markerV1, markerV2))
+	}
+	if c.Backend != backendRules && c.Backend != backendRewrites {
+		errs = append(errs, fmt.Errorf("unsupported backend %q, expected %q or %q", c.Backend, backendRules, backendRewrites))
+	}
+	for _, t := range c.RecordTypes {
+		if !endpointSupported(&endpoint.Endpoint{RecordType: t}) {
+			errs = append(errs, fmt.Errorf("unsupported record type %q", t))
+		}
+	}
+	if _, err := newDomainFilter(c.DomainFilter); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.Server.ListenAddress == "" {
+		errs = append(errs, errors.New("server listen address is required"))
+	}
+	if c.Server.ReadTimeout.Duration <= 0 {
+		errs = append(errs, errors.New("server read timeout must be positive"))
+	}
+	if c.Server.WriteTimeout.Duration <= 0 {
+		errs = append(errs, errors.New("server write timeout must be positive"))
+	}
+	if (c.Server.TLS.CertFile == "") != (c.Server.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("server TLS certificate and key files must be set together"))
+	}
+	if c.Server.TLS.ClientCAFile != "" && c.Server.TLS.CertFile == "" {
+		errs = append(errs, errors.New("server TLS client CA requires a server certificate"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateInstanceURL(s string) error {
+	if u, err := url.Parse(s); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url %q must be an absolute http or https URL", s)
+	}
+	return nil
+}
+
+// primaryInstance returns the configuration of the instance at URL
+func (c *Config) primaryInstance() InstanceConfig {
+	return InstanceConfig{
+		Name:         instanceName("", c.URL),
+		URL:          c.URL,
+		User:         c.User,
+		Password:     c.Password,
+		UserFile:     c.UserFile,
+		PasswordFile: c.PasswordFile,
+	}
+}
+
+// InstanceNames returns names of the configured AdguardHome instances, the primary instance first.
+func (c *Config) InstanceNames() []string {
+	ret := []string{c.primaryInstance().Name}
+	for _, replica := range c.replicaInstances() {
+		ret = append(ret, replica.Name)
+	}
+	return ret
+}
+
+// replicaInstances returns configurations of replicas with names and missing credentials filled in
+func (c *Config) replicaInstances() []InstanceConfig {
+	ret := make([]InstanceConfig, 0, len(c.Replicas))
+	for _, replica := range c.Replicas {
+		replica.Name = instanceName(replica.Name, replica.URL)
+		if replica.User == "" && replica.UserFile == "" {
+			replica.User, replica.UserFile = c.User, c.UserFile
+		}
+		if replica.Password == "" && replica.PasswordFile == "" {
+			replica.Password, replica.PasswordFile = c.Password, c.PasswordFile
+		}
+		ret = append(ret, replica)
+	}
+	return ret
+}
+
+func instanceName(name, rawURL string) string {
+	if name != "" {
+		return name
+	}
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return rawURL
+}
+
+// validateSecret checks that exactly one of the value and the file is set.
+func validateSecret(name, value, valueEnv, file, fileEnv string) error {
+	switch {
+	case value == "" && file == "":
+		return fmt.Errorf("%s is required, set it in the config file or environment variables %s or %s", name, valueEnv, fileEnv)
+	case value != "" && file != "":
+		return fmt.Errorf("%s and %s file can't be set at the same time", name, name)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package adguardhome
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := `
+url: http://adguard.home:3000
+user: admin
+password: from-file
+managedByRef: cluster
+recordTypes: [A, TXT]
+domainFilter:
+  include: [example.com]
+server:
+  listenAddress: 127.0.0.1:8080
+  readTimeout: 30s
+replicas:
+- url: https://adguard-2.home
+`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(envPassword, "from-env")
+	t.Setenv(envTimeout, "5s")
+	t.Setenv(envRetryMaxAttempts, "5")
+	t.Setenv(envMaxDeletionPercent, "25")
+	t.Setenv(envDryRun, "1")
+	t.Setenv(envExcludeDomains, "private.example.com, ,internal.example.com")
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	expected := &Config{
+		URL:                 "http://adguard.home:3000",
+		User:                "admin",
+		Password:            "from-env",
+		ManagedByRef:        "cluster",
+		MarkerVersion:       markerV2,
+		Backend:             backendRules,
+		RecordsSource:       recordsSourcePrimary,
+		DryRun:              true,
+		DryRunDiff:          dryRunDiffManaged,
+		Timeout:             Duration{5 * time.Second},
+		HealthCheckInterval: Duration{30 * time.Second},
+		Retry: RetryConfig{
+			MaxAttempts:     5,
+			InitialInterval: Duration{500 * time.Millisecond},
+			MaxInterval:     Duration{5 * time.Second},
+		},
+		StartupMode:     startupModeFail,
+		ConflictRetries: 3,
+		Backup:          BackupConfig{Retention: 10},
+		DeletionGuard:   DeletionGuardConfig{MaxDeletionPercent: 25},
+		RecordTypes:     []string{"A", "TXT"},
+		DomainFilter: DomainFilterOptions{
+			Include: []string{"example.com"},
+			Exclude: []string{"private.example.com", "internal.example.com"},
+		},
+		Server: ServerConfig{
+			ListenAddress: "127.0.0.1:8080",
+			ReadTimeout:   Duration{30 * time.Second},
+			WriteTimeout:  Duration{10 * time.Second},
+		},
+		Replicas: []InstanceConfig{{URL: "https://adguard-2.home"}},
+	}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("LoadConfig() = %+v, want %+v", cfg, expected)
+	}
+
+	expectedReplicas := []InstanceConfig{{Name: "adguard-2.home", URL: "https://adguard-2.home", User: "admin", Password: "from-env"}}
+	if replicas := cfg.replicaInstances(); !reflect.DeepEqual(replicas, expectedReplicas) {
+		t.Errorf("replicaInstances() = %+v, want %+v", replicas, expectedReplicas)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
+
+func TestLoadConfig_InvalidEnv(t *testing.T) {
+	t.Setenv(envDryRun, "yes")
+	t.Setenv(envInsecureSkipVerify, "flase")
+	t.Setenv(envRetryMaxAttempts, "many")
+
+	_, err := LoadConfig("")
+	if err == nil {
+		t.Fatal("expected error for invalid environment variables")
+	}
+	for _, name := range []string{envDryRun, envInsecureSkipVerify, envRetryMaxAttempts} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("expected error to mention %s, got %v", name, err)
+		}
+	}
+}
+
+func TestLoadConfig_UnknownField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"url": "http://adguard.home", "pasword": "typo"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadConfig(path); err == nil {
+		t.Errorf("expected error for unknown field")
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.URL = "adguard.home"
+	cfg.Backend = "hosts"
+	cfg.RecordTypes = []string{"NAPTR"}
+	cfg.DomainFilter = DomainFilterOptions{Include: []string{"example.com"}, Regex: "example"}
+	cfg.Server.ReadTimeout = Duration{}
+	cfg.Replicas = []InstanceConfig{{URL: "ftp://adguard-2.home"}}
+	cfg.RecordsSource = "all"
+	cfg.DeletionGuard.MaxDeletionPercent = 150
+	cfg.MarkerVersion = 3
+	cfg.ManagedByRef = "my cluster"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) {
+		t.Fatalf("expected joined errors, got %T", err)
+	}
+	// url, replica url, records source, max deletion percent, user, password, managed by ref, marker version, backend,
+	// record type, domain filter and read timeout
+	if got := len(joined.Unwrap()); got != 12 {
+		t.Errorf("expected 12 validation errors, got %d: %v", got, err)
+	}
+}
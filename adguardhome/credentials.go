@@ -0,0 +1,99 @@
+package adguardhome
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// credentials holds basic auth credentials of the AdguardHome API.
+// Values read from files are re-read whenever the files change, so rotated secrets are picked up without a restart.
+type credentials struct {
+	mu   sync.Mutex
+	user credential
+	pass credential
+}
+
+type credential struct {
+	value   string
+	path    string
+	modTime time.Time
+}
+
+func newCredentials(user, userFile, pass, passFile string) (*credentials, error) {
+	c := &credentials{
+		user: credential{value: user, path: userFile},
+		pass: credential{value: pass, path: passFile},
+	}
+
+	if _, err := c.reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// get returns current credentials, re-reading files modified since the last read.
+func (c *credentials) get() (string, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := c.user.refresh(false); err != nil {
+		return "", "", err
+	}
+	if _, err := c.pass.refresh(false); err != nil {
+		return "", "", err
+	}
+
+	return c.user.value, c.pass.value, nil
+}
+
+// reload re-reads credential files and reports whether any of the values changed.
+func (c *credentials) reload() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	userChanged, err := c.user.refresh(true)
+	if err != nil {
+		return false, err
+	}
+	passChanged, err := c.pass.refresh(true)
+	if err != nil {
+		return false, err
+	}
+
+	return userChanged || passChanged, nil
+}
+
+// fromFiles returns true if any of the values is read from a file.
+func (c *credentials) fromFiles() bool {
+	return c.user.path != "" || c.pass.path != ""
+}
+
+func (c *credential) refresh(force bool) (bool, error) {
+	if c.path == "" {
+		return false, nil
+	}
+
+	st, err := os.Stat(c.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read credentials: %w", err)
+	}
+	if !force && st.ModTime().Equal(c.modTime) {
+		return false, nil
+	}
+
+	b, err := os.ReadFile(c.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read credentials: %w", err)
+	}
+
+	value := strings.TrimSpace(string(b))
+	changed := value != c.value
+	c.value = value
+	c.modTime = st.ModTime()
+
+	return changed, nil
+}
@@ -0,0 +1,95 @@
+package adguardhome
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12"}
+	b := []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "ten", "11", "12"}
+
+	expected := `--- a
++++ b
+@@ -1,3 +1,4 @@
++0
+ 1
+ 2
+ 3
+@@ -7,6 +8,6 @@
+ 7
+ 8
+ 9
+-10
++ten
+ 11
+ 12
+`
+	if got := unifiedDiff("a", "b", a, b); got != expected {
+		t.Errorf("unifiedDiff() =\n%s\nwant\n%s", got, expected)
+	}
+
+	if got := unifiedDiff("a", "b", a, a); got != "" {
+		t.Errorf("expected empty diff of equal lines, got\n%s", got)
+	}
+
+	expected = `--- a
++++ b
+@@ -1,2 +0,0 @@
+-1
+-2
+`
+	if got := unifiedDiff("a", "b", []string{"1", "2"}, nil); got != expected {
+		t.Errorf("unifiedDiff() =\n%s\nwant\n%s", got, expected)
+	}
+}
+
+func TestAdguardHomeProvider_DryRunDiff(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+
+	c := newMockClient()
+	original := c.rules
+	p := &AdguardHomeProvider{client: c, dryRun: true, dryRunDiff: dryRunDiffManaged}
+
+	err := p.ApplyChanges(context.Background(), &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			{DNSName: "new.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"2.2.2.2"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.saves != 0 || len(c.rules) != len(original) {
+		t.Fatalf("expected rules not to be saved in dry run, got %v", c.rules)
+	}
+
+	var diff string
+	for _, e := range hook.AllEntries() {
+		if strings.HasPrefix(e.Message, "dry run: filtering rules would change") {
+			diff = e.Message
+		}
+	}
+	if !strings.Contains(diff, "\n+2.2.2.2 new.example.com #$managed by external-dns\n") {
+		t.Errorf("expected the created rule in the diff, got %q", diff)
+	}
+	if strings.Contains(diff, "I am not for external-dns") {
+		t.Errorf("expected unmanaged rules to be omitted from the diff, got %q", diff)
+	}
+
+	hook.Reset()
+	p.dryRunDiff = dryRunDiffFull
+	if err := p.ApplyChanges(context.Background(), &plan.Changes{}); err != nil {
+		t.Fatal(err)
+	}
+	if e := hook.LastEntry(); e == nil || !strings.Contains(e.Message, " # I am not for external-dns\n") {
+		t.Errorf("expected unmanaged rules in the full diff, got %v", e)
+	}
+}
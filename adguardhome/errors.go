@@ -0,0 +1,78 @@
+package adguardhome
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Classes of AdguardHome API errors, use errors.Is to check the class of an error returned by the provider.
+var (
+	// ErrUnauthorized is returned when AdguardHome rejects the credentials
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNotFound is returned when the API endpoint or the object doesn't exist, e.g. an unsupported AdguardHome version
+	ErrNotFound = errors.New("not found")
+	// ErrServer is returned when AdguardHome fails to process a request
+	ErrServer = errors.New("server error")
+	// ErrNetwork is returned when no response was received from AdguardHome
+	ErrNetwork = errors.New("network error")
+	// ErrConflict is returned when filtering rules kept changing concurrently while changes were applied
+	ErrConflict = errors.New("filtering rules changed concurrently")
+	// ErrMassDeletion is returned when changes would delete more managed records than allowed by the deletion guard
+	ErrMassDeletion = errors.New("too many managed records deleted")
+)
+
+// APIError is a failed request to the AdguardHome API.
+type APIError struct {
+	Method string
+	Path   string
+	// StatusCode is 0 when no response was received
+	StatusCode int
+	// Body is the beginning of the response body, AdguardHome explains rejected requests there
+	Body string
+	// Err is the transport error when no response was received
+	Err error
+}
+
+func (e *APIError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("%s %s: %s: %v", e.Method, e.Path, ErrNetwork, e.Err)
+	}
+
+	msg := fmt.Sprintf("%s %s: unexpected status code %d", e.Method, e.Path, e.StatusCode)
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+// Unwrap returns the class of the error and the transport error.
+func (e *APIError) Unwrap() []error {
+	var errs []error
+	if class := e.class(); class != nil {
+		errs = append(errs, class)
+	}
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	return errs
+}
+
+func (e *APIError) class() error {
+	switch {
+	case e.StatusCode == 0:
+		return ErrNetwork
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode >= http.StatusInternalServerError:
+		return ErrServer
+	}
+	return nil
+}
+
+// transient returns true for failures which could succeed when retried.
+func (e *APIError) transient() bool {
+	return e.StatusCode == http.StatusTooManyRequests || errors.Is(e, ErrServer) || errors.Is(e, ErrNetwork)
+}
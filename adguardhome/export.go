@@ -0,0 +1,106 @@
+package adguardhome
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/yaml"
+)
+
+// Formats of exported records, only YAML and JSON can be imported
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+	FormatZone = "zone"
+)
+
+// WriteRecords writes endpoints, e.g. returned by Records, to w in the given format.
+func WriteRecords(w io.Writer, endpoints []*endpoint.Endpoint, format string) error {
+	if endpoints == nil {
+		endpoints = []*endpoint.Endpoint{}
+	}
+
+	switch format {
+	case FormatYAML:
+		data, err := yaml.Marshal(endpoints)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(endpoints)
+	case FormatZone:
+		return writeZone(w, endpoints)
+	}
+
+	return fmt.Errorf("unsupported format %q, expected %q, %q or %q", format, FormatYAML, FormatJSON, FormatZone)
+}
+
+// ReadRecords parses endpoints written by WriteRecords in the YAML or JSON format.
+func ReadRecords(data []byte) ([]*endpoint.Endpoint, error) {
+	var endpoints []*endpoint.Endpoint
+	if err := yaml.UnmarshalStrict(data, &endpoints); err != nil {
+		return nil, err
+	}
+
+	for i, e := range endpoints {
+		if e == nil || e.DNSName == "" || e.RecordType == "" || len(e.Targets) == 0 {
+			return nil, fmt.Errorf("record %d: dnsName, recordType and targets are required", i)
+		}
+	}
+	return endpoints, nil
+}
+
+// writeZone writes endpoints as resource records of a zone file, labels are kept in comments.
+// AdguardHome rules have no TTL, so records use the TTL of the zone.
+func writeZone(w io.Writer, endpoints []*endpoint.Endpoint) error {
+	for _, e := range endpoints {
+		comment := ""
+		if len(e.Labels) > 0 {
+			labelsJSON, err := json.Marshal(e.Labels)
+			if err != nil {
+				return err
+			}
+			comment = " ; labels=" + string(labelsJSON)
+		}
+
+		for _, target := range e.Targets {
+			if _, err := fmt.Fprintf(w, "%s IN %s %s%s\n", fqdn(e.DNSName), e.RecordType, zoneData(e.RecordType, target), comment); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// zoneData returns the target in the presentation format of the record type
+func zoneData(recordType, target string) string {
+	switch recordType {
+	case endpoint.RecordTypeCNAME, endpoint.RecordTypePTR, endpoint.RecordTypeMX, endpoint.RecordTypeSRV:
+		// The host name is the last field of these record types
+		fields := strings.Fields(target)
+		if len(fields) > 0 {
+			fields[len(fields)-1] = fqdn(fields[len(fields)-1])
+		}
+		return strings.Join(fields, " ")
+	case endpoint.RecordTypeTXT:
+		if len(target) >= 2 && strings.HasPrefix(target, `"`) && strings.HasSuffix(target, `"`) {
+			return target
+		}
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(target) + `"`
+	}
+	return target
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
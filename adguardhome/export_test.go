@@ -0,0 +1,69 @@
+package adguardhome
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+func TestWriteRecords(t *testing.T) {
+	endpoints := []*endpoint.Endpoint{
+		{DNSName: "example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"1.1.1.1", "2.2.2.2"}, Labels: endpoint.Labels{"owner": "default"}},
+		{DNSName: "*.apps.example.com", RecordType: endpoint.RecordTypeAAAA, Targets: endpoint.Targets{"2001:db8::1"}},
+		{DNSName: "a-example.com", RecordType: endpoint.RecordTypeTXT, Targets: endpoint.Targets{`heritage=external-dns,"quoted"`}},
+		{DNSName: "app.example.com", RecordType: endpoint.RecordTypeCNAME, Targets: endpoint.Targets{"lb.example.net"}},
+		{DNSName: "example.com", RecordType: endpoint.RecordTypeMX, Targets: endpoint.Targets{"10 mail.example.com"}},
+		{DNSName: "_sip._tcp.example.com", RecordType: endpoint.RecordTypeSRV, Targets: endpoint.Targets{"10 5 5060 sip.example.com."}},
+	}
+
+	var zone bytes.Buffer
+	if err := WriteRecords(&zone, endpoints, FormatZone); err != nil {
+		t.Fatal(err)
+	}
+	expectedZone := `example.com. IN A 1.1.1.1 ; labels={"owner":"default"}
+example.com. IN A 2.2.2.2 ; labels={"owner":"default"}
+*.apps.example.com. IN AAAA 2001:db8::1
+a-example.com. IN TXT "heritage=external-dns,\"quoted\""
+app.example.com. IN CNAME lb.example.net.
+example.com. IN MX 10 mail.example.com.
+_sip._tcp.example.com. IN SRV 10 5 5060 sip.example.com.
+`
+	if zone.String() != expectedZone {
+		t.Errorf("unexpected zone:\n%s\nexpected:\n%s", zone.String(), expectedZone)
+	}
+
+	for _, format := range []string{FormatYAML, FormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteRecords(&buf, endpoints, format); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ReadRecords(buf.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, endpoints) {
+				t.Errorf("records do not match: got: %v, expected: %v", got, endpoints)
+			}
+		})
+	}
+
+	if err := WriteRecords(&bytes.Buffer{}, endpoints, "csv"); err == nil {
+		t.Error("expected unsupported format error")
+	}
+}
+
+func TestReadRecords_Invalid(t *testing.T) {
+	for _, data := range []string{
+		"- dnsName: example.com\n  recordType: A\n",
+		"- dnsName: example.com\n  recordType: A\n  targets: [1.1.1.1]\n  ttl: 300\n",
+		"dnsName: example.com",
+	} {
+		if _, err := ReadRecords([]byte(data)); err == nil {
+			t.Errorf("expected error for %q", data)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package adguardhome
+
+import (
+	"fmt"
+	"maps"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// checkDeletions refuses changing rules of the instance from before to after when the change deletes more managed
+// records than allowed by the deletion guard, so a misconfigured source stalls the sync instead of wiping DNS.
+func (p *AdguardHomeProvider) checkDeletions(instance string, before, after []string) error {
+	g := p.deletionGuard
+	if g.MaxDeletions == 0 && g.MaxDeletionPercent == 0 {
+		return nil
+	}
+
+	total, deleted := p.countDeletedRecords(before, after)
+
+	var limit string
+	switch {
+	case g.MaxDeletions > 0 && deleted > g.MaxDeletions:
+		limit = fmt.Sprintf("%d records", g.MaxDeletions)
+	case g.MaxDeletionPercent > 0 && deleted*100 > g.MaxDeletionPercent*total:
+		limit = fmt.Sprintf("%d%% of records", g.MaxDeletionPercent)
+	default:
+		return nil
+	}
+
+	logger := log.WithField("instance", instance)
+	if g.AllowMassDeletion {
+		logger.Warnf("deleting %d of %d managed records exceeds the limit of %s, applying as mass deletion is allowed", deleted, total, limit)
+		return nil
+	}
+
+	massDeletionsBlockedTotal.WithLabelValues(instance).Inc()
+	logger.Errorf("refusing to delete %d of %d managed records", deleted, total)
+	return fmt.Errorf("%w: %d of %d managed records would be deleted, exceeding the limit of %s; "+
+		"check the sources of external-dns or set allowMassDeletion to apply the changes", ErrMassDeletion, deleted, total, limit)
+}
+
+// countDeletedRecords returns the number of managed records in before and how many of them are missing in after.
+// Records are compared by name, type and target, so changing labels doesn't count as a deletion.
+func (p *AdguardHomeProvider) countDeletedRecords(before, after []string) (total, deleted int) {
+	remaining := p.managedRecordKeys(after)
+	for key, count := range p.managedRecordKeys(before) {
+		total += count
+		deleted += max(count-remaining[key], 0)
+	}
+	return total, deleted
+}
+
+// managedRecordKeys counts managed records in rules, records stored as DNS rewrites are counted by their ownership rules.
+func (p *AdguardHomeProvider) managedRecordKeys(rules []string) map[targetKey]int {
+	o := p.owner()
+	parser := newRuleParser(rules, o)
+	keys := make(map[targetKey]int)
+	for _, rule := range rules {
+		if e, err := parser.parse(rule); err == nil {
+			keys[targetKey{recordKey{e.DNSName, e.RecordType}, e.Targets[0]}]++
+		} else if entry, _, err := parseRewriteOwnership(rule, o); err == nil {
+			keys[targetKey{recordKey{entry.Domain, rewriteRecordType(entry.Answer)}, entry.Answer}]++
+		}
+	}
+	return keys
+}
+
+// targetKey identifies a single target of a record set
+type targetKey struct {
+	recordKey
+	target string
+}
+
+// simulateRewriteOps returns ownership of rewrites after successfully applying ops, without changing owned.
+func simulateRewriteOps(owned map[RewriteEntry]endpoint.Labels, ops []rewriteOp) map[RewriteEntry]endpoint.Labels {
+	ret := make(map[RewriteEntry]endpoint.Labels, len(owned))
+	maps.Copy(ret, owned)
+	for _, op := range ops {
+		switch op.action {
+		case rewriteActionAdd:
+			ret[op.update] = op.labels
+		case rewriteActionDelete:
+			delete(ret, op.target)
+		case rewriteActionUpdate:
+			delete(ret, op.target)
+			ret[op.update] = op.labels
+		}
+	}
+	return ret
+}
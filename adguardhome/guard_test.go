@@ -0,0 +1,149 @@
+package adguardhome
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func TestAdguardHomeProvider_DeletionGuard(t *testing.T) {
+	rules := []string{
+		"# I am not for external-dns",
+		"1.1.1.1 a.example.com #$managed by external-dns",
+		"1.1.1.2 b.example.com #$managed by external-dns",
+		"1.1.1.3 c.example.com #$managed by external-dns",
+		"1.1.1.4 d.example.com #$managed by external-dns",
+		"@@||a.example.com #$managed by external-dns",
+		"@@||b.example.com #$managed by external-dns",
+		"@@||c.example.com #$managed by external-dns",
+		"@@||d.example.com #$managed by external-dns",
+	}
+	deleteRecords := func(names ...string) *plan.Changes {
+		changes := &plan.Changes{}
+		for i, name := range names {
+			changes.Delete = append(changes.Delete, &endpoint.Endpoint{
+				DNSName:    name + ".example.com",
+				RecordType: endpoint.RecordTypeA,
+				Targets:    endpoint.Targets{"1.1.1." + string(rune('1'+i))},
+			})
+		}
+		return changes
+	}
+
+	tests := []struct {
+		name    string
+		guard   DeletionGuardConfig
+		changes *plan.Changes
+		blocked bool
+	}{
+		{
+			name:    "disabled",
+			changes: deleteRecords("a", "b", "c", "d"),
+		},
+		{
+			name:    "within count",
+			guard:   DeletionGuardConfig{MaxDeletions: 2},
+			changes: deleteRecords("a", "b"),
+		},
+		{
+			name:    "exceeds count",
+			guard:   DeletionGuardConfig{MaxDeletions: 2},
+			changes: deleteRecords("a", "b", "c"),
+			blocked: true,
+		},
+		{
+			name:    "within percent",
+			guard:   DeletionGuardConfig{MaxDeletionPercent: 50},
+			changes: deleteRecords("a", "b"),
+		},
+		{
+			name:    "exceeds percent",
+			guard:   DeletionGuardConfig{MaxDeletionPercent: 50},
+			changes: deleteRecords("a", "b", "c"),
+			blocked: true,
+		},
+		{
+			name:    "allowed",
+			guard:   DeletionGuardConfig{MaxDeletions: 1, AllowMassDeletion: true},
+			changes: deleteRecords("a", "b", "c", "d"),
+		},
+		{
+			name:  "label changes",
+			guard: DeletionGuardConfig{MaxDeletions: 1},
+			changes: &plan.Changes{
+				UpdateOld: deleteRecords("a", "b").Delete,
+				UpdateNew: []*endpoint.Endpoint{
+					{DNSName: "a.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"1.1.1.1"}, Labels: endpoint.Labels{"owner": "new"}},
+					{DNSName: "b.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"1.1.1.2"}, Labels: endpoint.Labels{"owner": "new"}},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &mockAdguardClient{rules: slices.Clone(rules)}
+			p := &AdguardHomeProvider{client: c, primaryName: tt.name, deletionGuard: tt.guard}
+
+			err := p.ApplyChanges(context.Background(), tt.changes)
+			if tt.blocked != errors.Is(err, ErrMassDeletion) {
+				t.Fatalf("expected blocked %v, got %v", tt.blocked, err)
+			}
+			if tt.blocked {
+				if c.saves != 0 {
+					t.Errorf("expected no save, got %d", c.saves)
+				}
+				if got := testutil.ToFloat64(massDeletionsBlockedTotal.WithLabelValues(tt.name)); got != 1 {
+					t.Errorf("expected 1 blocked deletion, got %v", got)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestAdguardHomeProvider_DeletionGuardRewrites(t *testing.T) {
+	c := newMockClient()
+	c.rules = []string{
+		"! rewrite a.example.com 1.1.1.1 #$managed by external-dns",
+		"! rewrite b.example.com 1.1.1.2 #$managed by external-dns",
+	}
+	c.rewrites = []RewriteEntry{
+		{Domain: "a.example.com", Answer: "1.1.1.1"},
+		{Domain: "b.example.com", Answer: "1.1.1.2"},
+	}
+	p := &AdguardHomeProvider{
+		client:        c,
+		backend:       backendRewrites,
+		deletionGuard: DeletionGuardConfig{MaxDeletions: 1},
+	}
+
+	err := p.ApplyChanges(context.Background(), &plan.Changes{
+		Delete: []*endpoint.Endpoint{
+			{DNSName: "a.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"1.1.1.1"}},
+			{DNSName: "b.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"1.1.1.2"}},
+		},
+	})
+	if !errors.Is(err, ErrMassDeletion) {
+		t.Fatalf("expected mass deletion error, got %v", err)
+	}
+
+	// Nothing is deleted, not even the rewrites changed before the rules are saved
+	expected := []RewriteEntry{
+		{Domain: "a.example.com", Answer: "1.1.1.1"},
+		{Domain: "b.example.com", Answer: "1.1.1.2"},
+	}
+	if !reflect.DeepEqual(c.rewrites, expected) {
+		t.Errorf("rewrites do not match: got: %v, expected: %v", c.rewrites, expected)
+	}
+	if c.saves != 0 {
+		t.Errorf("expected no save, got %d", c.saves)
+	}
+}
@@ -0,0 +1,95 @@
+package adguardhome
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var errHealthNotChecked = errors.New("health of AdguardHome was not checked yet")
+
+// health holds the result of the last health check of AdguardHome instances.
+type health struct {
+	mu  sync.RWMutex
+	err error
+	// checked is false until the first check completes
+	checked bool
+}
+
+func (h *health) get() error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if !h.checked {
+		return errHealthNotChecked
+	}
+	return h.err
+}
+
+// set stores the result of a check and returns the previous one.
+func (h *health) set(err error) (previous error, checked bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	previous, checked = h.err, h.checked
+	h.err, h.checked = err, true
+	return previous, checked
+}
+
+// CheckHealth checks every AdguardHome instance and stores the result reported by Ready.
+func (p *AdguardHomeProvider) CheckHealth(ctx context.Context) error {
+	var errs []error
+	for _, inst := range p.instances() {
+		err := inst.client.Health(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("instance %s: %w", inst.name, err))
+			instanceUp.WithLabelValues(inst.name).Set(0)
+		} else {
+			instanceUp.WithLabelValues(inst.name).Set(1)
+		}
+	}
+	err := errors.Join(errs...)
+
+	previous, checked := p.health.set(err)
+	switch {
+	case err != nil && (previous == nil || !checked):
+		log.WithError(err).Warn("AdguardHome is degraded")
+	case err == nil && previous != nil:
+		log.Info("AdguardHome recovered")
+	}
+
+	return err
+}
+
+// Ready returns nil when the last health check succeeded or the reason the provider is degraded.
+func (p *AdguardHomeProvider) Ready() error {
+	return p.health.get()
+}
+
+// StartHealthChecks checks health of AdguardHome in the background, immediately and then every interval
+// until ctx is done. Ready reports the provider as not ready until the first check completes.
+func (p *AdguardHomeProvider) StartHealthChecks(ctx context.Context, interval time.Duration) {
+	check := func() {
+		checkCtx, cancel := context.WithTimeout(ctx, interval)
+		defer cancel()
+		_ = p.CheckHealth(checkCtx)
+	}
+
+	go func() {
+		check()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				check()
+			}
+		}
+	}()
+}
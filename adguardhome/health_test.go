@@ -0,0 +1,108 @@
+package adguardhome
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_Health(t *testing.T) {
+	user, pass := "admin", "secret"
+	srv := newTestServer(t, &user, &pass)
+
+	creds, err := newCredentials(user, "", pass, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &client{hc: srv.Client(), endpoint: srv.URL + "/control/", creds: creds}
+	if err := c.Health(context.Background()); err != nil {
+		t.Errorf("Health() error = %v", err)
+	}
+
+	pass = "rotated"
+	if err := c.Health(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected error for rejected credentials, got %v", err)
+	}
+
+	disabled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/control/filtering/status" {
+			_, _ = w.Write([]byte(`{"enabled": false, "user_rules": []}`))
+		}
+	}))
+	t.Cleanup(disabled.Close)
+
+	c = &client{hc: disabled.Client(), endpoint: disabled.URL + "/control/", creds: &credentials{}}
+	if err := c.Health(context.Background()); err == nil || err.Error() != "filtering is disabled" {
+		t.Errorf("expected disabled filtering error, got %v", err)
+	}
+}
+
+func TestAdguardHomeProvider_Ready(t *testing.T) {
+	replica := newMockClient()
+	p := &AdguardHomeProvider{
+		client:      newMockClient(),
+		primaryName: "primary",
+		replicas:    []instance{{name: "replica", client: replica}},
+	}
+
+	if err := p.Ready(); !errors.Is(err, errHealthNotChecked) {
+		t.Errorf("expected provider not to be ready before the first check, got %v", err)
+	}
+
+	if err := p.CheckHealth(context.Background()); err != nil {
+		t.Fatalf("CheckHealth() error = %v", err)
+	}
+	if err := p.Ready(); err != nil {
+		t.Errorf("Ready() error = %v", err)
+	}
+
+	replica.err = errors.New("connection refused")
+	if err := p.CheckHealth(context.Background()); err == nil {
+		t.Fatal("expected error of the unreachable replica")
+	}
+	if err := p.Ready(); err == nil || !strings.Contains(err.Error(), "instance replica: connection refused") {
+		t.Errorf("expected reason of the degraded replica, got %v", err)
+	}
+}
+
+// blockingHealthClient blocks health checks until release is closed.
+type blockingHealthClient struct {
+	*mockAdguardClient
+	release chan struct{}
+}
+
+func (c *blockingHealthClient) Health(ctx context.Context) error {
+	select {
+	case <-c.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestAdguardHomeProvider_StartHealthChecks(t *testing.T) {
+	c := &blockingHealthClient{mockAdguardClient: newMockClient(), release: make(chan struct{})}
+	p := &AdguardHomeProvider{client: c, primaryName: "primary"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The first check must not block the caller, e.g. the start of the webhook server
+	p.StartHealthChecks(ctx, time.Minute)
+	if err := p.Ready(); !errors.Is(err, errHealthNotChecked) {
+		t.Errorf("expected provider not to be ready before the first check, got %v", err)
+	}
+
+	close(c.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Ready() != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected provider to be ready after the first check, got %v", p.Ready())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
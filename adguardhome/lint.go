@@ -0,0 +1,163 @@
+package adguardhome
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// quarantinePrefix comments out malformed managed rules, quarantined rules are ignored by the provider
+const quarantinePrefix = "! edns-quarantined "
+
+// Classes of filtering rules reported by LintRules
+const (
+	// RuleUnmanaged is a rule not owned by the provider
+	RuleUnmanaged = "unmanaged"
+	// RuleManaged is a valid rule owned by the provider
+	RuleManaged = "managed"
+	// RuleMalformed is a rule owned by the provider which fails to parse, it fails Records until fixed
+	RuleMalformed = "malformed"
+	// RuleOrphan is an artificial rule of a domain without address records or an ownership rule of a missing $dnsrewrite rule
+	RuleOrphan = "orphan"
+	// RuleQuarantined is a malformed rule commented out by LintRules
+	RuleQuarantined = "quarantined"
+)
+
+// Fixes of malformed and orphan rules applied by LintRules
+const (
+	// FixRepair removes malformed and orphan rules, external-dns recreates records of removed rules by the next sync
+	FixRepair = "repair"
+	// FixQuarantine comments out malformed rules for manual inspection and removes orphan rules
+	FixQuarantine = "quarantine"
+)
+
+// RuleLint is the class of a filtering rule.
+type RuleLint struct {
+	Rule  string
+	Class string
+	// Err explains why a malformed rule failed to parse
+	Err error
+}
+
+// LintReport holds classes of filtering rules of an instance.
+type LintReport struct {
+	Instance string
+	Rules    []RuleLint
+}
+
+// Problems returns the number of malformed and orphan rules.
+func (r LintReport) Problems() int {
+	n := 0
+	for _, rule := range r.Rules {
+		if rule.Class == RuleMalformed || rule.Class == RuleOrphan {
+			n++
+		}
+	}
+	return n
+}
+
+// LintRules classifies filtering rules of every instance. When fix is FixRepair or FixQuarantine, problems found
+// are fixed and the rules are saved, the returned reports describe the rules before the fix.
+func (p *AdguardHomeProvider) LintRules(ctx context.Context, fix string) ([]LintReport, error) {
+	if fix != "" && fix != FixRepair && fix != FixQuarantine {
+		return nil, fmt.Errorf("unsupported fix %q, expected %q or %q", fix, FixRepair, FixQuarantine)
+	}
+
+	var reports []LintReport
+	for _, inst := range p.instances() {
+		rules, err := inst.client.GetFilteringRules(ctx)
+		if err != nil {
+			return reports, fmt.Errorf("instance %s: %w", inst.name, err)
+		}
+		report := LintReport{Instance: inst.name, Rules: p.lintRules(rules)}
+		reports = append(reports, report)
+
+		if fix == "" || report.Problems() == 0 {
+			continue
+		}
+		err = p.saveRules(ctx, inst, rules, func(rules []string) ([]string, error) {
+			return p.fixRules(rules, fix), nil
+		}, nil)
+		if err != nil {
+			return reports, fmt.Errorf("instance %s: %w", inst.name, err)
+		}
+	}
+
+	return reports, nil
+}
+
+func (p *AdguardHomeProvider) lintRules(rules []string) []RuleLint {
+	o := p.owner()
+
+	// Artificial rules belong to domains of address records stored as hosts-style rules
+	domains := make(map[string]struct{})
+	copies := make(map[string]int, len(rules))
+	parser := newRuleParser(rules, o)
+	for _, rule := range rules {
+		if e, err := parser.parse(rule); err == nil && isAddressRecord(e) && !strings.HasPrefix(rule, "|") {
+			domains[e.DNSName] = struct{}{}
+		}
+		copies[rule]++
+	}
+
+	// The n-th ownership rule of any owner owns the n-th copy of its $dnsrewrite rule
+	ownerships := make(map[string]int)
+	parser = newRuleParser(rules, o)
+	ret := make([]RuleLint, 0, len(rules))
+	for _, rule := range rules {
+		lint := RuleLint{Rule: rule, Class: RuleManaged}
+
+		owned, _, ownershipErr := parseRuleOwnership(rule)
+		if ownershipErr == nil {
+			ownerships[owned]++
+		}
+
+		_, err := parser.parse(rule)
+		switch {
+		case strings.HasPrefix(rule, quarantinePrefix):
+			lint.Class = RuleQuarantined
+		case err == nil:
+		case errors.Is(err, errNotManaged):
+			lint.Class = RuleUnmanaged
+		case errors.Is(err, errArtificialRecord):
+			// Exceptions of wildcard records are owned through ownership rules and have no marker
+			body, _, err := parseMarker(rule)
+			if _, ok := domains[strings.TrimPrefix(body, "@@||")]; err == nil && !ok {
+				lint.Class = RuleOrphan
+			}
+		case errors.Is(err, errRewriteOwnership):
+			if _, _, err := parseRewriteOwnership(rule, o); err != nil {
+				lint.Class, lint.Err = RuleMalformed, err
+			}
+		case errors.Is(err, errRuleOwnership):
+			if _, err := parseDNSRewriteRule(rule, strings.TrimPrefix(owned, "@@"), nil); err != nil {
+				lint.Class, lint.Err = RuleMalformed, err
+			} else if ownerships[owned] > copies[owned] {
+				lint.Class = RuleOrphan
+			}
+		default:
+			lint.Class, lint.Err = RuleMalformed, err
+		}
+
+		ret = append(ret, lint)
+	}
+	return ret
+}
+
+func (p *AdguardHomeProvider) fixRules(rules []string, fix string) []string {
+	ret := make([]string, 0, len(rules))
+	for _, lint := range p.lintRules(rules) {
+		switch lint.Class {
+		case RuleOrphan:
+			continue
+		case RuleMalformed:
+			if fix == FixQuarantine {
+				ret = append(ret, quarantinePrefix+lint.Rule)
+			}
+			continue
+		}
+		ret = append(ret, lint.Rule)
+	}
+	return ret
+}
@@ -0,0 +1,109 @@
+package adguardhome
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestAdguardHomeProvider_LintRules(t *testing.T) {
+	rules := []string{
+		"# I am not for external-dns",
+		"1.1.1.1 example.com #$managed by external-dns",
+		"@@||example.com #$managed by external-dns",
+		"@@||gone.example.com #$managed by external-dns",
+		"||mail.example.com^$dnsrewrite=NOERROR;MX;mail.example.com #$managed by external-dns",
+		"! rewrite app.example.com #$managed by external-dns",
+		"1.1.1.1 other.example.com #$managed by external-dns;ref:other",
+		"! edns-quarantined 1.1.1.1 broken #$managed by external-dns",
+		"! rule ||app.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net #$managed by external-dns",
+		"||app.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net",
+		"! rule ||gone.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net #$managed by external-dns",
+	}
+	expectedClasses := []string{
+		RuleUnmanaged,
+		RuleManaged,
+		RuleManaged,
+		RuleOrphan,
+		RuleMalformed,
+		RuleMalformed,
+		RuleUnmanaged,
+		RuleQuarantined,
+		RuleManaged,
+		RuleManaged,
+		RuleOrphan,
+	}
+
+	tests := []struct {
+		fix      string
+		expected []string
+	}{
+		{
+			expected: rules,
+		},
+		{
+			fix: FixRepair,
+			expected: []string{
+				"# I am not for external-dns",
+				"1.1.1.1 example.com #$managed by external-dns",
+				"@@||example.com #$managed by external-dns",
+				"1.1.1.1 other.example.com #$managed by external-dns;ref:other",
+				"! edns-quarantined 1.1.1.1 broken #$managed by external-dns",
+				"! rule ||app.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net #$managed by external-dns",
+				"||app.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net",
+			},
+		},
+		{
+			fix: FixQuarantine,
+			expected: []string{
+				"# I am not for external-dns",
+				"1.1.1.1 example.com #$managed by external-dns",
+				"@@||example.com #$managed by external-dns",
+				"! edns-quarantined ||mail.example.com^$dnsrewrite=NOERROR;MX;mail.example.com #$managed by external-dns",
+				"! edns-quarantined ! rewrite app.example.com #$managed by external-dns",
+				"1.1.1.1 other.example.com #$managed by external-dns;ref:other",
+				"! edns-quarantined 1.1.1.1 broken #$managed by external-dns",
+				"! rule ||app.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net #$managed by external-dns",
+				"||app.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run("fix "+tt.fix, func(t *testing.T) {
+			c := &mockAdguardClient{rules: append([]string(nil), rules...)}
+			p := &AdguardHomeProvider{client: c}
+
+			reports, err := p.LintRules(context.Background(), tt.fix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(reports) != 1 || reports[0].Problems() != 4 {
+				t.Fatalf("expected 4 problems of a single instance, got %+v", reports)
+			}
+
+			var classes []string
+			for _, lint := range reports[0].Rules {
+				classes = append(classes, lint.Class)
+				if (lint.Class == RuleMalformed) != (lint.Err != nil) {
+					t.Errorf("unexpected error of %s rule %s: %v", lint.Class, lint.Rule, lint.Err)
+				}
+			}
+			if !reflect.DeepEqual(classes, expectedClasses) {
+				t.Errorf("classes do not match: got: %v, expected: %v", classes, expectedClasses)
+			}
+
+			if !reflect.DeepEqual(c.rules, tt.expected) {
+				t.Errorf("rules do not match: got: %v, expected: %v", c.rules, tt.expected)
+			}
+			if tt.fix == "" {
+				return
+			}
+
+			// Records are readable again after the fix
+			if _, err := p.Records(context.Background()); err != nil {
+				t.Errorf("failed to fetch records: %v", err)
+			}
+		})
+	}
+}
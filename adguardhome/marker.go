@@ -0,0 +1,164 @@
+package adguardhome
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// Versions of the ownership marker appended to managed rules.
+// Rules are read in every version and written in the configured one, so rules are migrated by the next save.
+const (
+	// markerV1 is `$managed by external-dns;ref:<ref>;labels=<json>`, the ref and labels are optional
+	markerV1 = 1
+	// markerV2 is `#edns:v2 owner=<ref> labels=<base64 json>`, the owner and labels are optional
+	markerV2 = 2
+)
+
+const (
+	markerV2Prefix = "#edns:v2"
+
+	markerV1Ref    = ";ref:"
+	markerV1Labels = ";labels="
+
+	markerV2Owner  = "owner="
+	markerV2Labels = "labels="
+)
+
+// owner identifies rules managed by the provider.
+type owner struct {
+	// ref is the owner reference, empty for the default owner
+	ref string
+	// version of the marker written to rules, markerV1 unless markerV2 is set
+	version int
+}
+
+// marker is the ownership marker parsed from a managed rule.
+type marker struct {
+	ref    string
+	labels endpoint.Labels
+}
+
+// comment returns the marker written to rules of the owner, including the leading '#'.
+func (o owner) comment(labels endpoint.Labels) string {
+	if o.version == markerV2 {
+		var b strings.Builder
+		b.WriteString(markerV2Prefix)
+		if o.ref != "" {
+			b.WriteString(" " + markerV2Owner + o.ref)
+		}
+		if len(labels) > 0 {
+			if labelsJSON, err := json.Marshal(labels); err == nil {
+				b.WriteString(" " + markerV2Labels + base64.RawURLEncoding.EncodeToString(labelsJSON))
+			}
+		}
+		return b.String()
+	}
+
+	s := "#" + managedBy
+	if o.ref != "" {
+		s += markerV1Ref + o.ref
+	}
+	return s + labelsToString(labels)
+}
+
+// mark appends the marker of the owner to the rule body.
+func (o owner) mark(body string, labels endpoint.Labels) string {
+	comment := o.comment(labels)
+	// TXT rules are comments already, so the v1 marker follows without '#'
+	if o.version != markerV2 && strings.HasPrefix(body, "#") {
+		comment = strings.TrimPrefix(comment, "#")
+	}
+	return body + " " + comment
+}
+
+// validateRef checks that the owner reference can be written to markers of every version.
+// Fields of markers are separated by whitespace, ';' and '=', so references containing them would be read back
+// as a different owner.
+func validateRef(ref string) error {
+	if strings.ContainsFunc(ref, func(r rune) bool { return unicode.IsSpace(r) || r == ';' || r == '=' }) {
+		return fmt.Errorf("owner reference %q must not contain whitespace, ';' or '='", ref)
+	}
+	return nil
+}
+
+// owns returns true when the marker belongs to the owner, references have to match exactly.
+func (o owner) owns(m marker) bool {
+	return m.ref == o.ref
+}
+
+// parseMarker splits rule into the part before the ownership marker and the marker,
+// errNotManaged is returned for rules without a marker of any version and for quarantined rules.
+func parseMarker(rule string) (string, marker, error) {
+	if strings.HasPrefix(rule, quarantinePrefix) {
+		return "", marker{}, errNotManaged
+	}
+
+	if idx := strings.Index(rule, " "+markerV2Prefix); idx != -1 {
+		rest := rule[idx+1+len(markerV2Prefix):]
+		if rest == "" || rest[0] == ' ' {
+			return rule[:idx], parseMarkerV2(rest), nil
+		}
+	}
+
+	if idx := strings.Index(rule, managedBy); idx != -1 {
+		m, ok := parseMarkerV1(rule[idx+len(managedBy):])
+		if !ok {
+			return "", marker{}, errNotManaged
+		}
+		// The marker follows " #" in every v1 rule except TXT rules, which are comments already
+		body := strings.TrimSuffix(strings.TrimRight(rule[:idx], " "), " #")
+		return strings.TrimRight(body, " "), m, nil
+	}
+
+	return "", marker{}, errNotManaged
+}
+
+func parseMarkerV1(s string) (marker, bool) {
+	var m marker
+	if idx := strings.Index(s, markerV1Labels); idx != -1 {
+		labelsJSON := s[idx+len(markerV1Labels):]
+		m.labels = make(endpoint.Labels)
+		if err := json.Unmarshal([]byte(labelsJSON), &m.labels); err != nil {
+			log.Warnf("failed to parse labels %s: %v", labelsJSON, err)
+			m.labels = nil
+		}
+		s = s[:idx]
+	}
+
+	switch {
+	case s == "":
+	case strings.HasPrefix(s, markerV1Ref):
+		m.ref = strings.TrimPrefix(s, markerV1Ref)
+	default:
+		return marker{}, false
+	}
+	return m, true
+}
+
+// parseMarkerV2 parses fields of a v2 marker, unknown fields are ignored to allow adding fields later.
+func parseMarkerV2(s string) marker {
+	var m marker
+	for _, field := range strings.Fields(s) {
+		switch {
+		case strings.HasPrefix(field, markerV2Owner):
+			m.ref = strings.TrimPrefix(field, markerV2Owner)
+		case strings.HasPrefix(field, markerV2Labels):
+			m.labels = nil
+			labelsJSON, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(field, markerV2Labels))
+			if err == nil {
+				err = json.Unmarshal(labelsJSON, &m.labels)
+			}
+			if err != nil {
+				log.Warnf("failed to parse labels %s: %v", field, err)
+				m.labels = nil
+			}
+		}
+	}
+	return m
+}
@@ -0,0 +1,187 @@
+package adguardhome
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func TestParseRule_Owner(t *testing.T) {
+	labels := endpoint.Labels{"owner": "default"}
+	tests := []struct {
+		name     string
+		rule     string
+		owner    owner
+		expected *endpoint.Endpoint
+	}{
+		{
+			name:     "v1 default",
+			rule:     "1.1.1.1 example.com #$managed by external-dns",
+			expected: &endpoint.Endpoint{DNSName: "example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"1.1.1.1"}},
+		},
+		{
+			name: "v1 default doesn't own refs",
+			rule: "1.1.1.1 example.com #$managed by external-dns;ref:prod",
+		},
+		{
+			name:     "v1 ref with labels",
+			rule:     `1.1.1.1 example.com #$managed by external-dns;ref:prod;labels={"owner":"default"}`,
+			owner:    owner{ref: "prod"},
+			expected: &endpoint.Endpoint{DNSName: "example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"1.1.1.1"}, Labels: labels},
+		},
+		{
+			name:  "v1 ref prefix",
+			rule:  "1.1.1.1 example.com #$managed by external-dns;ref:prod2",
+			owner: owner{ref: "prod"},
+		},
+		{
+			name:     "v2 default",
+			rule:     "# heritage=external-dns a-example.com #edns:v2",
+			expected: &endpoint.Endpoint{DNSName: "a-example.com", RecordType: endpoint.RecordTypeTXT, Targets: endpoint.Targets{"heritage=external-dns"}},
+		},
+		{
+			name:     "v2 ref with labels",
+			rule:     "||app.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net #edns:v2 owner=prod labels=eyJvd25lciI6ImRlZmF1bHQifQ",
+			owner:    owner{ref: "prod"},
+			expected: &endpoint.Endpoint{DNSName: "app.example.com", RecordType: endpoint.RecordTypeCNAME, Targets: endpoint.Targets{"lb.example.net"}, Labels: labels},
+		},
+		{
+			name:  "v2 ref prefix",
+			rule:  "1.1.1.1 example.com #edns:v2 owner=prod2",
+			owner: owner{ref: "prod"},
+		},
+		{
+			name: "v2 default doesn't own refs",
+			rule: "1.1.1.1 example.com #edns:v2 owner=prod",
+		},
+		{
+			name: "unmanaged",
+			rule: "1.1.1.1 example.com #edns:v20",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRule(tt.rule, tt.owner)
+			if tt.expected == nil {
+				if !errors.Is(err, errNotManaged) {
+					t.Fatalf("expected rule not to be managed, got %v, %v", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("parseRule() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestValidateRef(t *testing.T) {
+	for _, ref := range []string{"", "prod", "cluster-1.example.com"} {
+		if err := validateRef(ref); err != nil {
+			t.Errorf("unexpected error for %q: %v", ref, err)
+		}
+	}
+
+	// "my cluster" would be written as "owner=my cluster" and owned by "my"
+	for _, ref := range []string{"my cluster", "prod\t", "prod;labels", "owner=prod"} {
+		if err := validateRef(ref); err == nil {
+			t.Errorf("expected error for %q", ref)
+		}
+	}
+
+	p := &AdguardHomeProvider{client: &mockAdguardClient{}}
+	if _, err := p.MigrateRules(context.Background(), "", "my cluster", markerV2, true); err == nil {
+		t.Error("expected migration to an invalid reference to fail")
+	}
+}
+
+func TestEndpointToString_MarkerV2(t *testing.T) {
+	o := owner{ref: "prod", version: markerV2}
+	labels := endpoint.Labels{"owner": "default"}
+	endpoints := []*endpoint.Endpoint{
+		{DNSName: "example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"1.1.1.1"}, Labels: labels},
+		{DNSName: "a-example.com", RecordType: endpoint.RecordTypeTXT, Targets: endpoint.Targets{"heritage=external-dns"}},
+		{DNSName: "app.example.com", RecordType: endpoint.RecordTypeCNAME, Targets: endpoint.Targets{"lb.example.net"}, Labels: labels},
+	}
+	expected := []string{
+		"1.1.1.1 example.com #edns:v2 owner=prod labels=eyJvd25lciI6ImRlZmF1bHQifQ",
+		"# heritage=external-dns a-example.com #edns:v2 owner=prod",
+		"|app.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net",
+	}
+
+	for i, e := range endpoints {
+		rule := endpointToString(e, o)
+		if rule != expected[i] {
+			t.Errorf("endpointToString() = %q, expected %q", rule, expected[i])
+		}
+		rules := []string{rule}
+		if isDNSRewriteRecord(e) {
+			rules = append(rules, ruleOwnershipToString(rule, e.Labels, o))
+		}
+		got, err := newRuleParser(rules, o).parse(rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, e) {
+			t.Errorf("parseRule() = %v, expected %v", got, e)
+		}
+	}
+
+	rule := ruleOwnershipToString(expected[2], labels, o)
+	if expectedRule := "! rule " + expected[2] + " #edns:v2 owner=prod labels=eyJvd25lciI6ImRlZmF1bHQifQ"; rule != expectedRule {
+		t.Errorf("ruleOwnershipToString() = %q, expected %q", rule, expectedRule)
+	}
+	if _, err := parseRule(rule, o); !errors.Is(err, errRuleOwnership) {
+		t.Errorf("expected ownership rule, got %v", err)
+	}
+
+	if rule := artificialRuleToString("example.com", o); rule != "@@||example.com #edns:v2 owner=prod" {
+		t.Errorf("unexpected artificial rule %q", rule)
+	}
+	if _, err := parseRule(artificialRuleToString("example.com", o), o); !errors.Is(err, errArtificialRecord) {
+		t.Errorf("expected artificial rule, got %v", err)
+	}
+}
+
+func TestAdguardHomeProvider_MigrateMarker(t *testing.T) {
+	c := &mockAdguardClient{rules: []string{
+		"# I am not for external-dns",
+		"1.1.1.1 example.com #$managed by external-dns;ref:prod",
+		"# heritage=external-dns a-example.com $managed by external-dns;ref:prod",
+		"@@||example.com #$managed by external-dns;ref:prod",
+		"2.2.2.2 other.example.com #$managed by external-dns;ref:prod2",
+		"@@||other.example.com #$managed by external-dns;ref:prod2",
+	}}
+	p := &AdguardHomeProvider{client: c, managedBySuffix: "prod", markerVersion: markerV2}
+
+	err := p.ApplyChanges(context.Background(), &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			{DNSName: "new.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"3.3.3.3"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rules of the provider are rewritten in v2, rules of other owners are kept as-is
+	expected := []string{
+		"# I am not for external-dns",
+		"2.2.2.2 other.example.com #$managed by external-dns;ref:prod2",
+		"@@||other.example.com #$managed by external-dns;ref:prod2",
+		"1.1.1.1 example.com #edns:v2 owner=prod",
+		"# heritage=external-dns a-example.com #edns:v2 owner=prod",
+		"3.3.3.3 new.example.com #edns:v2 owner=prod",
+		"@@||example.com #edns:v2 owner=prod",
+		"@@||new.example.com #edns:v2 owner=prod",
+	}
+	if !reflect.DeepEqual(c.rules, expected) {
+		t.Errorf("rules do not match: got: %v, expected: %v", c.rules, expected)
+	}
+}
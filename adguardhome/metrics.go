@@ -0,0 +1,156 @@
+package adguardhome
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+const metricsNamespace = "adguardhome_provider"
+
+var (
+	apiRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_requests_total",
+		Help:      "Number of requests made to the AdguardHome API by path and status code, status is \"error\" when no response was received.",
+	}, []string{"path", "status"})
+
+	apiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "Duration of requests made to the AdguardHome API by path.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"path"})
+
+	apiRetriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_retries_total",
+		Help:      "Number of retried requests to the AdguardHome API by path.",
+	}, []string{"path"})
+
+	applyChangesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "apply_changes_total",
+		Help:      "Number of ApplyChanges calls by result.",
+	}, []string{"result"})
+
+	applyChangesDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "apply_changes_duration_seconds",
+		Help:      "Duration of ApplyChanges calls.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	rulesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "rules",
+		Help:      "Number of filtering rules seen during the last read by ownership, managed includes artificial rules.",
+	}, []string{"ownership"})
+
+	ruleParseErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rule_parse_errors_total",
+		Help:      "Number of managed filtering rules which failed to parse.",
+	})
+
+	ruleSavesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rule_saves_total",
+		Help:      "Number of filtering rules saves by result, noop when the rules were already up to date and the save was skipped.",
+	}, []string{"result"})
+
+	ruleConflictsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rule_conflicts_total",
+		Help:      "Number of times filtering rules were changed concurrently while changes were applied.",
+	})
+
+	massDeletionsBlockedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "mass_deletions_blocked_total",
+		Help:      "Number of changes refused by instance because they would delete more managed records than allowed.",
+	}, []string{"instance"})
+
+	instanceUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "instance_up",
+		Help:      "Whether the last health check of the AdguardHome instance succeeded.",
+	}, []string{"instance"})
+
+	recordChangesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "record_changes_total",
+		Help:      "Number of record targets created or deleted by instance and record type, counted when the change is saved.",
+	}, []string{"action", "instance", "record_type"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		apiRequestsTotal,
+		apiRequestDuration,
+		apiRetriesTotal,
+		applyChangesTotal,
+		applyChangesDuration,
+		rulesGauge,
+		ruleParseErrorsTotal,
+		recordChangesTotal,
+		instanceUp,
+		ruleConflictsTotal,
+		ruleSavesTotal,
+		massDeletionsBlockedTotal,
+	)
+}
+
+// observeAPIRequest records a request to the AdguardHome API, statusCode is 0 when no response was received.
+func observeAPIRequest(path string, statusCode int, start time.Time) {
+	status := "error"
+	if statusCode != 0 {
+		status = strconv.Itoa(statusCode)
+	}
+	apiRequestsTotal.WithLabelValues(path, status).Inc()
+	apiRequestDuration.WithLabelValues(path).Observe(time.Since(start).Seconds())
+}
+
+func observeApplyChanges(err error, start time.Time) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	applyChangesTotal.WithLabelValues(result).Inc()
+	applyChangesDuration.Observe(time.Since(start).Seconds())
+}
+
+func observeRules(managed, unmanaged int) {
+	rulesGauge.WithLabelValues("managed").Set(float64(managed))
+	rulesGauge.WithLabelValues("unmanaged").Set(float64(unmanaged))
+}
+
+// observeRecordChanges counts record targets created and deleted on an instance by comparing its records
+// before and after a save, changed targets count as a deletion of the old and a creation of the new target.
+func observeRecordChanges(instance string, before, after []*endpoint.Endpoint) {
+	type recordTarget struct {
+		recordType, dnsName, target string
+	}
+	counts := make(map[recordTarget]int)
+	for _, e := range before {
+		for _, t := range e.Targets {
+			counts[recordTarget{e.RecordType, e.DNSName, t}]--
+		}
+	}
+	for _, e := range after {
+		for _, t := range e.Targets {
+			counts[recordTarget{e.RecordType, e.DNSName, t}]++
+		}
+	}
+
+	for k, n := range counts {
+		switch {
+		case n > 0:
+			recordChangesTotal.WithLabelValues("create", instance, k.recordType).Add(float64(n))
+		case n < 0:
+			recordChangesTotal.WithLabelValues("delete", instance, k.recordType).Add(float64(-n))
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package adguardhome
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func TestMetrics_ApplyChanges(t *testing.T) {
+	p := &AdguardHomeProvider{
+		client: newMockClient(),
+	}
+
+	successes := testutil.ToFloat64(applyChangesTotal.WithLabelValues("success"))
+	created := testutil.ToFloat64(recordChangesTotal.WithLabelValues("create", "primary", endpoint.RecordTypeA))
+	deleted := testutil.ToFloat64(recordChangesTotal.WithLabelValues("delete", "primary", endpoint.RecordTypeA))
+
+	err := p.ApplyChanges(context.Background(), &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			{DNSName: "new.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"2.2.2.2", "3.3.3.3"}},
+		},
+		Delete: []*endpoint.Endpoint{
+			{DNSName: "example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"1.1.1.1"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := testutil.ToFloat64(applyChangesTotal.WithLabelValues("success")) - successes; got != 1 {
+		t.Errorf("expected 1 successful ApplyChanges, got %v", got)
+	}
+	if got := testutil.ToFloat64(recordChangesTotal.WithLabelValues("create", "primary", endpoint.RecordTypeA)) - created; got != 2 {
+		t.Errorf("expected 2 created targets, got %v", got)
+	}
+	if got := testutil.ToFloat64(recordChangesTotal.WithLabelValues("delete", "primary", endpoint.RecordTypeA)) - deleted; got != 1 {
+		t.Errorf("expected 1 deleted target, got %v", got)
+	}
+	// The TXT rule is managed, the comment is not
+	if got := testutil.ToFloat64(rulesGauge.WithLabelValues("unmanaged")); got != 1 {
+		t.Errorf("expected 1 unmanaged rule, got %v", got)
+	}
+	if got := testutil.ToFloat64(rulesGauge.WithLabelValues("managed")); got != 2 {
+		t.Errorf("expected 2 managed rules, got %v", got)
+	}
+}
+
+func TestMetrics_RecordChangesNotSaved(t *testing.T) {
+	c := newMockClient()
+	p := &AdguardHomeProvider{client: c}
+	rewrites := &AdguardHomeProvider{client: c, backend: backendRewrites, managedBySuffix: "b"}
+	c.rules = append(c.rules, "! rewrite owned.example.com 4.4.4.4 #$managed by external-dns;ref:a")
+
+	counters := func() (total float64) {
+		for _, action := range []string{"create", "delete"} {
+			for _, recordType := range []string{endpoint.RecordTypeA, endpoint.RecordTypeNS} {
+				total += testutil.ToFloat64(recordChangesTotal.WithLabelValues(action, "primary", recordType))
+			}
+		}
+		return total
+	}
+	before := counters()
+
+	tests := []struct {
+		name     string
+		provider *AdguardHomeProvider
+		changes  *plan.Changes
+		dryRun   bool
+	}{
+		{
+			name:     "unsupported record type",
+			provider: p,
+			changes:  &plan.Changes{Create: []*endpoint.Endpoint{{DNSName: "ns.example.com", RecordType: endpoint.RecordTypeNS, Targets: endpoint.Targets{"ns1.example.com"}}}},
+		},
+		{
+			name:     "invalid target",
+			provider: p,
+			changes:  &plan.Changes{Create: []*endpoint.Endpoint{{DNSName: "new.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"not-an-ip"}}}},
+		},
+		{
+			name:     "existing record",
+			provider: p,
+			changes:  &plan.Changes{Create: []*endpoint.Endpoint{{DNSName: "example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"1.1.1.1"}}}},
+		},
+		{
+			name:     "dry run",
+			provider: p,
+			changes:  &plan.Changes{Create: []*endpoint.Endpoint{{DNSName: "new.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"2.2.2.2"}}}},
+			dryRun:   true,
+		},
+		{
+			name:     "rewrite of another owner",
+			provider: rewrites,
+			changes:  &plan.Changes{Create: []*endpoint.Endpoint{{DNSName: "owned.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"4.4.4.4"}}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.provider.dryRun = tt.dryRun
+			defer func() { tt.provider.dryRun = false }()
+
+			if err := tt.provider.ApplyChanges(context.Background(), tt.changes); err != nil {
+				t.Fatal(err)
+			}
+			if got := counters() - before; got != 0 {
+				t.Errorf("expected no counted record changes, got %v", got)
+			}
+		})
+	}
+}
+
+func TestMetrics_APIRequests(t *testing.T) {
+	user, pass := "admin", "secret"
+	srv := newTestServer(t, &user, &pass)
+	ok := testutil.ToFloat64(apiRequestsTotal.WithLabelValues("filtering/status", "200"))
+	unauthorized := testutil.ToFloat64(apiRequestsTotal.WithLabelValues("filtering/status", "401"))
+
+	creds, err := newCredentials(user, "", pass, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &client{hc: srv.Client(), endpoint: srv.URL + "/control/", creds: creds}
+	if _, err := c.GetFilteringRules(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pass = "rotated"
+	if _, err := c.GetFilteringRules(context.Background()); err == nil {
+		t.Fatal("expected error for rejected credentials")
+	}
+
+	if got := testutil.ToFloat64(apiRequestsTotal.WithLabelValues("filtering/status", "200")) - ok; got != 1 {
+		t.Errorf("expected 1 successful request, got %v", got)
+	}
+	if got := testutil.ToFloat64(apiRequestsTotal.WithLabelValues("filtering/status", "401")) - unauthorized; got != 1 {
+		t.Errorf("expected 1 unauthorized request, got %v", got)
+	}
+}
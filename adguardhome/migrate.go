@@ -0,0 +1,85 @@
+package adguardhome
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrOwnerCollision is returned when rules would be migrated to an owner reference which already owns rules
+var ErrOwnerCollision = errors.New("target owner already owns rules")
+
+// MigrateRules moves rules owned by the from reference to the to reference and rewrites their markers in the given
+// version on every instance, an empty reference stands for the default owner.
+// It returns a diff of the changes, which are saved unless dryRun is set.
+func (p *AdguardHomeProvider) MigrateRules(ctx context.Context, from, to string, version int, dryRun bool) (string, error) {
+	if version != markerV1 && version != markerV2 {
+		return "", fmt.Errorf("unsupported marker version %d, expected %d or %d", version, markerV1, markerV2)
+	}
+	if err := validateRef(to); err != nil {
+		return "", err
+	}
+	target := owner{ref: to, version: version}
+	migrate := func(rules []string) ([]string, error) {
+		return migrateRules(rules, from, target)
+	}
+
+	var diff strings.Builder
+	for _, inst := range p.instances() {
+		rules, err := inst.client.GetFilteringRules(ctx)
+		if err != nil {
+			return diff.String(), fmt.Errorf("instance %s: %w", inst.name, err)
+		}
+		migrated, err := migrate(rules)
+		if err != nil {
+			return diff.String(), fmt.Errorf("instance %s: %w", inst.name, err)
+		}
+		diff.WriteString(unifiedDiff(inst.name+"/current", inst.name+"/migrated", rules, migrated))
+
+		if dryRun {
+			continue
+		}
+		if err := p.saveRules(ctx, inst, rules, migrate, nil); err != nil {
+			return diff.String(), fmt.Errorf("instance %s: %w", inst.name, err)
+		}
+	}
+
+	return diff.String(), nil
+}
+
+// migrateRules rewrites markers of rules owned by the from reference for the target owner.
+// Rules of both owners would be indistinguishable afterwards, so rules already owned by the target are refused.
+func migrateRules(rules []string, from string, target owner) ([]string, error) {
+	ret := make([]string, 0, len(rules))
+	collisions := 0
+	for _, rule := range rules {
+		body, m, err := parseMarker(rule)
+		switch {
+		case err != nil:
+			ret = append(ret, rule)
+		case m.ref == from && strings.HasPrefix(body, "||"):
+			// $dnsrewrite rules with a marker are written by previous versions, the marker is moved to an ownership rule
+			ret = append(ret, ruleOwnershipToString(body, m.labels, target), body)
+		case m.ref == from:
+			ret = append(ret, target.mark(body, m.labels))
+		default:
+			if m.ref == target.ref {
+				collisions++
+			}
+			ret = append(ret, rule)
+		}
+	}
+
+	if collisions > 0 {
+		return nil, fmt.Errorf("%w: %d rules are owned by %s already", ErrOwnerCollision, collisions, ownerName(target.ref))
+	}
+	return ret, nil
+}
+
+func ownerName(ref string) string {
+	if ref == "" {
+		return "the default owner"
+	}
+	return fmt.Sprintf("%q", ref)
+}
@@ -0,0 +1,74 @@
+package adguardhome
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAdguardHomeProvider_MigrateRules(t *testing.T) {
+	rules := []string{
+		"# I am not for external-dns",
+		`1.1.1.1 example.com #$managed by external-dns;ref:old;labels={"owner":"default"}`,
+		"# heritage=external-dns a-example.com $managed by external-dns;ref:old",
+		"@@||example.com #$managed by external-dns;ref:old",
+		"2.2.2.2 other.example.com #$managed by external-dns",
+		"||app.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net #$managed by external-dns;ref:old",
+	}
+	c := &mockAdguardClient{rules: append([]string(nil), rules...)}
+	replica := &mockAdguardClient{rules: append([]string(nil), rules...)}
+	p := &AdguardHomeProvider{client: c, replicas: []instance{{name: "replica", client: replica}}}
+
+	diff, err := p.MigrateRules(context.Background(), "old", "new", markerV2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.saves != 0 || replica.saves != 0 {
+		t.Errorf("expected no saves in the dry run, got %d and %d", c.saves, replica.saves)
+	}
+	for _, line := range []string{"--- primary/current", "--- replica/current", "+# heritage=external-dns a-example.com #edns:v2 owner=new"} {
+		if !strings.Contains(diff, line) {
+			t.Errorf("expected diff to contain %q, got:\n%s", line, diff)
+		}
+	}
+
+	_, err = p.MigrateRules(context.Background(), "old", "new", markerV2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"# I am not for external-dns",
+		"1.1.1.1 example.com #edns:v2 owner=new labels=eyJvd25lciI6ImRlZmF1bHQifQ",
+		"# heritage=external-dns a-example.com #edns:v2 owner=new",
+		"@@||example.com #edns:v2 owner=new",
+		"2.2.2.2 other.example.com #$managed by external-dns",
+		"! rule ||app.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net #edns:v2 owner=new",
+		"||app.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net",
+	}
+	for _, got := range [][]string{c.rules, replica.rules} {
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("rules do not match: got: %v, expected: %v", got, expected)
+		}
+	}
+
+	// The default owner has a rule of its own, so moving rules of "new" to it would take it over
+	_, err = p.MigrateRules(context.Background(), "new", "", markerV1, false)
+	if !errors.Is(err, ErrOwnerCollision) {
+		t.Fatalf("expected owner collision, got %v", err)
+	}
+	if !reflect.DeepEqual(c.rules, expected) {
+		t.Errorf("rules changed despite the collision: %v", c.rules)
+	}
+
+	// Changing the marker version only
+	_, err = p.MigrateRules(context.Background(), "", "", markerV2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.rules[4]; got != "2.2.2.2 other.example.com #edns:v2" {
+		t.Errorf("unexpected migrated rule %q", got)
+	}
+}
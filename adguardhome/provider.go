@@ -5,10 +5,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 	"sigs.k8s.io/external-dns/provider"
 
 	"sigs.k8s.io/external-dns/endpoint"
@@ -18,17 +24,39 @@ import (
 var (
 	errNotManaged       = fmt.Errorf("rule is managed by external-dns")
 	errArtificialRecord = fmt.Errorf("artificial domain rule")
+	errRewriteOwnership = fmt.Errorf("dns rewrite ownership rule")
+	errRuleOwnership    = fmt.Errorf("$dnsrewrite ownership rule")
 )
 
 const (
 	managedBy = "$managed by external-dns"
 
-	envURL       = "ADGUARD_HOME_URL"
-	envPassword  = "ADGUARD_HOME_PASS"
-	envUser      = "ADGUARD_HOME_USER"
-	envManagedBy = "ADGUARD_HOME_MANAGED_BY_REF"
+	dnsRewriteModifier = "^$dnsrewrite="
+	dnsRewriteRCode    = "NOERROR"
+
+	// ruleOwnershipPrefix starts comment rules recording $dnsrewrite rules owned by the provider.
+	// AdguardHome only allows trailing comments in hosts-style rules, modifiers of other rules extend to the end
+	// of the line, so $dnsrewrite rules are kept without a marker and owned through a comment rule holding a copy.
+	ruleOwnershipPrefix = "! rule "
+
+	backendRules    = "rules"
+	backendRewrites = "rewrites"
+
+	recordsSourcePrimary = "primary"
+	recordsSourceUnion   = "union"
+
+	dryRunDiffManaged = "managed"
+	dryRunDiffFull    = "full"
 )
 
+// dnsRewriteRecordTypes lists record types which are stored as $dnsrewrite rules
+var dnsRewriteRecordTypes = map[string]struct{}{
+	endpoint.RecordTypeCNAME: {},
+	endpoint.RecordTypeMX:    {},
+	endpoint.RecordTypeSRV:   {},
+	endpoint.RecordTypePTR:   {},
+}
+
 type AdguardHomeProvider struct {
 	provider.BaseProvider
 
@@ -37,15 +65,111 @@ type AdguardHomeProvider struct {
 	domainFilter *endpoint.DomainFilter
 
 	managedBySuffix string
+
+	// backend selects how records are stored in AdguardHome: as filtering rules or as DNS rewrites
+	backend string
+
+	// recordTypes limits record types managed by the provider, all supported types are managed when empty
+	recordTypes map[string]struct{}
+
+	// primaryName is used to report failures of the instance served by client
+	primaryName string
+	// replicas are additional instances receiving every change applied to the primary instance
+	replicas []instance
+	// recordsSource selects whether records are read from the primary instance or from all instances
+	recordsSource string
+
+	health health
+	// conn holds the reason AdguardHome can't be used yet in the background startup mode
+	conn connection
+	// conflictRetries limits merging changes again when filtering rules are changed concurrently
+	conflictRetries int
+	// backups stores rules before they are changed, nil when backups are disabled
+	backups *backups
+	// dryRun logs changes of filtering rules instead of saving them
+	dryRun bool
+	// dryRunDiff selects whether dry run diffs include all rules or only managed ones
+	dryRunDiff string
+	// markerVersion is the version of the ownership marker written to rules
+	markerVersion int
+	// deletionGuard limits deletions of managed records in a single batch
+	deletionGuard DeletionGuardConfig
+}
+
+// instance is a single AdguardHome server managed by the provider
+type instance struct {
+	name   string
+	client Client
 }
 
 // NewAdguardHomeProvider initializes a new AdguardHome based provider
-func NewAdguardHomeProvider(dryRun bool) (*AdguardHomeProvider, error) {
-	adguardHomeURL, adguardHomeUrlOk := os.LookupEnv(envURL)
-	if !adguardHomeUrlOk {
-		return nil, fmt.Errorf("no url was found in environment variable ADGUARD_HOME_URL")
+func NewAdguardHomeProvider(cfg *Config) (*AdguardHomeProvider, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	domainFilter, err := newDomainFilter(cfg.DomainFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	hc, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	retry := cfg.Retry.policy()
+	primary, err := newInstance(cfg.primaryInstance(), hc, retry, cfg.DryRun)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &AdguardHomeProvider{
+		client:          primary.client,
+		primaryName:     primary.name,
+		domainFilter:    domainFilter,
+		managedBySuffix: cfg.ManagedByRef,
+		backend:         cfg.Backend,
+		recordsSource:   cfg.RecordsSource,
+		conflictRetries: cfg.ConflictRetries,
+		dryRun:          cfg.DryRun,
+		dryRunDiff:      cfg.DryRunDiff,
+		deletionGuard:   cfg.DeletionGuard,
+		markerVersion:   cfg.MarkerVersion,
+	}
+	// Nothing is written in the dry run mode
+	if !cfg.DryRun {
+		p.backups = newBackups(cfg.Backup)
+	}
+	if len(cfg.RecordTypes) > 0 {
+		p.recordTypes = make(map[string]struct{}, len(cfg.RecordTypes))
+		for _, t := range cfg.RecordTypes {
+			p.recordTypes[t] = struct{}{}
+		}
+	}
+
+	for _, replicaConfig := range cfg.replicaInstances() {
+		replica, err := newInstance(replicaConfig, hc, retry, cfg.DryRun)
+		if err != nil {
+			return nil, err
+		}
+		p.replicas = append(p.replicas, replica)
 	}
 
+	if cfg.StartupMode == startupModeBackground {
+		p.connectInBackground(context.Background())
+	} else if err := p.connect(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to connect to AdguardHome: %w", err)
+	}
+
+	log.Debugf("AdguardHome provider started with %d instances and %s backend", len(p.replicas)+1, cfg.Backend)
+
+	return p, nil
+}
+
+func newInstance(cfg InstanceConfig, hc *http.Client, retry retryPolicy, dryRun bool) (instance, error) {
+	adguardHomeURL := cfg.URL
+
 	// Adjust the URL to match the API requirements
 	if !strings.HasSuffix(adguardHomeURL, "/") {
 		adguardHomeURL = adguardHomeURL + "/"
@@ -55,78 +179,255 @@ func NewAdguardHomeProvider(dryRun bool) (*AdguardHomeProvider, error) {
 		adguardHomeURL = adguardHomeURL + "control/"
 	}
 
-	adguardHomeUser, adguardHomeUserOk := os.LookupEnv(envUser)
-	if !adguardHomeUserOk {
-		return nil, fmt.Errorf("no user was found in environment variable ADGUARD_HOME_USER")
+	creds, err := newCredentials(cfg.User, cfg.UserFile, cfg.Password, cfg.PasswordFile)
+	if err != nil {
+		return instance{}, fmt.Errorf("instance %s: %w", cfg.Name, err)
 	}
 
-	adguardHomePass, adguardHomePassOk := os.LookupEnv(envPassword)
-	if !adguardHomePassOk {
-		return nil, fmt.Errorf("no password was found in environment variable ADGUARD_HOME_PASS")
+	c := newAdguardHomeClient(adguardHomeURL, hc, creds, retry, dryRun)
+
+	log.Debugf("AdguardHome instance %s uses url %s", cfg.Name, adguardHomeURL)
+
+	return instance{name: cfg.Name, client: c}, nil
+}
+
+// instances returns the primary instance followed by replicas
+func (p *AdguardHomeProvider) instances() []instance {
+	name := p.primaryName
+	if name == "" {
+		name = "primary"
 	}
 
-	c, err := newAdguardHomeClient(adguardHomeURL, adguardHomeUser, adguardHomePass, dryRun)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create the adguard home api hс: %w", err)
+	return append([]instance{{name: name, client: p.client}}, p.replicas...)
+}
+
+func newDomainFilter(opts DomainFilterOptions) (*endpoint.DomainFilter, error) {
+	if opts.Regex == "" && opts.RegexExclusion == "" {
+		return endpoint.NewDomainFilterWithExclusions(opts.Include, opts.Exclude), nil
 	}
-	managedBySuffix, _ := os.LookupEnv(envManagedBy)
 
-	p := &AdguardHomeProvider{
-		client:          c,
-		domainFilter:    &endpoint.DomainFilter{},
-		managedBySuffix: managedBySuffix,
+	if len(opts.Include) > 0 || len(opts.Exclude) > 0 {
+		return nil, fmt.Errorf("domain filter lists can't be combined with regex domain filters")
 	}
 
-	log.Debugf("AdguardHome provider started with url %s", adguardHomeURL)
+	var regex, regexExclusion *regexp.Regexp
+	var err error
+	if opts.Regex != "" {
+		regex, err = regexp.Compile(opts.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex domain filter: %w", err)
+		}
+	}
+	if opts.RegexExclusion != "" {
+		regexExclusion, err = regexp.Compile(opts.RegexExclusion)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex domain exclusion: %w", err)
+		}
+	}
 
-	return p, nil
+	return endpoint.NewRegexDomainFilter(regex, regexExclusion), nil
 }
 
-func (p *AdguardHomeProvider) getManagedBy() string {
-	if p.managedBySuffix == "" {
-		return managedBy
-	}
+// GetDomainFilter returns the domain filter advertised to external-dns during negotiation.
+func (p *AdguardHomeProvider) GetDomainFilter() endpoint.DomainFilterInterface {
+	return p.domainFilter
+}
 
-	return fmt.Sprintf("%s;ref:%s", managedBy, p.managedBySuffix)
+// owner returns the owner of rules managed by the provider
+func (p *AdguardHomeProvider) owner() owner {
+	return owner{ref: p.managedBySuffix, version: p.markerVersion}
 }
 
 // ApplyChanges implements Provider, syncing desired state with the AdguardHome server Local DNS.
-func (p *AdguardHomeProvider) ApplyChanges(ctx context.Context, changes *plan.Changes) error {
+func (p *AdguardHomeProvider) ApplyChanges(ctx context.Context, changes *plan.Changes) (err error) {
 	log.Debugf("ApplyChanges: %+v", changes)
 
-	originalRules, err := p.client.GetFilteringRules(ctx)
+	start := time.Now()
+	ctx, span := startSpan(ctx, "ApplyChanges", attribute.String("backend", p.backend))
+	defer func() {
+		observeApplyChanges(err, start)
+		endSpan(span, err)
+	}()
+
+	if err := p.conn.get(); err != nil {
+		return err
+	}
+
+	changes = filterChanges(changes, func(e *endpoint.Endpoint) bool {
+		if !p.recordTypeEnabled(e.RecordType) {
+			log.Debugf("skipping record %s: record type %s is disabled", e, e.RecordType)
+			return false
+		}
+		if !p.domainFilter.Match(e.DNSName) {
+			log.Warnf("refusing to change record %s: domain does not match the domain filter", e)
+			return false
+		}
+		return true
+	})
+	span.SetAttributes(changesAttributes(changes)...)
+
+	var errs []error
+	for _, inst := range p.instances() {
+		instCtx, instSpan := startSpan(ctx, "ApplyChanges instance", attribute.String("instance", inst.name))
+		err := p.applyChangesTo(instCtx, inst, changes)
+		endSpan(instSpan, err)
+		if err != nil {
+			log.WithField("instance", inst.name).WithError(err).Error("failed to apply changes")
+			errs = append(errs, fmt.Errorf("instance %s: %w", inst.name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// applyChangesTo applies changes to a single AdguardHome instance.
+func (p *AdguardHomeProvider) applyChangesTo(ctx context.Context, inst instance, changes *plan.Changes) error {
+	if p.backend == backendRewrites {
+		return p.applyRewriteChanges(ctx, inst, changes)
+	}
+
+	c := inst.client
+
+	originalRules, err := c.GetFilteringRules(ctx)
 	if err != nil {
 		return err
 	}
 
 	log.Debugf("loaded existing rules: %+v", originalRules)
 
+	return p.saveRules(ctx, inst, originalRules, func(rules []string) ([]string, error) {
+		_, span := startSpan(ctx, "applyRuleChanges", attribute.Int("rules.count", len(rules)))
+		resultingRules, err := p.applyRuleChanges(rules, changes)
+		if err == nil {
+			err = p.checkDeletions(inst.name, rules, resultingRules)
+		}
+		span.SetAttributes(attribute.Int("rules.resulting", len(resultingRules)))
+		endSpan(span, err)
+		return resultingRules, err
+	}, p.observeSavedRecords)
+}
+
+// saveRules saves rules computed by merge from the rules read by the caller.
+// Rules are read again right before saving, if they were changed concurrently, e.g. edited in the AdguardHome UI,
+// the result is merged again from the current rules instead of overwriting the edit.
+// onSaved, when not nil, is called with the replaced and the saved rules once they are saved.
+func (p *AdguardHomeProvider) saveRules(ctx context.Context, inst instance, rules []string, merge func([]string) ([]string, error), onSaved func(instance string, previous, saved []string)) error {
+	c := inst.client
+	for attempt := 1; ; attempt++ {
+		resultingRules, err := merge(rules)
+		if err != nil {
+			return err
+		}
+		if slices.Equal(resultingRules, rules) {
+			log.WithField("instance", inst.name).Debug("filtering rules are up to date, skipping save")
+			ruleSavesTotal.WithLabelValues("noop").Inc()
+			return nil
+		}
+		if p.dryRun {
+			p.logDryRunDiff(inst.name, rules, resultingRules)
+			return nil
+		}
+
+		current, err := c.GetFilteringRules(ctx)
+		if err != nil {
+			return err
+		}
+		if slices.Equal(current, rules) {
+			if err := p.backup(inst.name, current, resultingRules); err != nil {
+				return err
+			}
+			if err := c.SaveFilteringRules(ctx, resultingRules); err != nil {
+				return err
+			}
+			ruleSavesTotal.WithLabelValues("saved").Inc()
+			if onSaved != nil {
+				onSaved(inst.name, current, resultingRules)
+			}
+			return nil
+		}
+
+		ruleConflictsTotal.Inc()
+		if attempt > p.conflictRetries {
+			return fmt.Errorf("%w: gave up after %d attempts", ErrConflict, attempt)
+		}
+		log.WithField("attempt", attempt).Warn("filtering rules changed while applying changes, merging changes again")
+		rules = current
+	}
+}
+
+// observeSavedRecords counts changes of the records managed by the provider made by saving the rules.
+func (p *AdguardHomeProvider) observeSavedRecords(instance string, previous, saved []string) {
+	before, err := p.savedRecords(previous)
+	if err != nil {
+		log.WithError(err).Warn("failed to count record changes")
+		return
+	}
+	after, err := p.savedRecords(saved)
+	if err != nil {
+		log.WithError(err).Warn("failed to count record changes")
+		return
+	}
+	observeRecordChanges(instance, before, after)
+}
+
+// savedRecords returns the records managed by the provider according to the rules,
+// including DNS rewrites owned by the rewrites backend.
+func (p *AdguardHomeProvider) savedRecords(rules []string) ([]*endpoint.Endpoint, error) {
+	records, _, err := p.parseRuleRecords(rules)
+	if err != nil || p.backend != backendRewrites {
+		return records, err
+	}
+
+	owned, err := p.ownedRewrites(rules)
+	if err != nil {
+		return nil, err
+	}
+	for entry := range owned {
+		records = append(records, &endpoint.Endpoint{
+			DNSName:    entry.Domain,
+			RecordType: rewriteRecordType(entry.Answer),
+			Targets:    endpoint.Targets{entry.Answer},
+		})
+	}
+
+	return records, nil
+}
+
+// applyRuleChanges computes the filtering rules resulting from applying changes to the given rules.
+// Rules not managed by the provider are kept as-is.
+func (p *AdguardHomeProvider) applyRuleChanges(originalRules []string, changes *plan.Changes) ([]string, error) {
 	resultingRules := make([]string, 0)
+	// Every managed rule holds exactly one target, so endpoints are kept one per rule
+	// to allow deleting individual targets.
 	endpoints := make([]*endpoint.Endpoint, 0)
-	endpointsAExists := make(map[string]*endpoint.Endpoint)
-	suffix := p.getManagedBy()
+	o := p.owner()
+	parser := newRuleParser(originalRules, o)
+	unmanaged := 0
 	for _, rule := range originalRules {
-		e, err := parseRule(rule, suffix)
+		e, err := parser.parse(rule)
 		if err != nil {
-			// Keep rules not managed by external-dns as-is
-			if errors.Is(err, errNotManaged) {
+			// Keep rules not managed by external-dns as-is, as well as the rewrites ownership table
+			if errors.Is(err, errNotManaged) || errors.Is(err, errRewriteOwnership) {
+				if errors.Is(err, errNotManaged) {
+					unmanaged++
+				}
 				resultingRules = append(resultingRules, rule)
 				continue
 			}
-			// Skip artificial rules we manage; they will be reconstructed
-			if errors.Is(err, errArtificialRecord) {
+			// Skip artificial and ownership rules we manage; they will be reconstructed
+			if errors.Is(err, errArtificialRecord) || errors.Is(err, errRuleOwnership) {
 				continue
 			}
-			return fmt.Errorf("failed to parse rule %s: %w", rule, err)
+			ruleParseErrorsTotal.Inc()
+			return nil, fmt.Errorf("failed to parse rule %s: %w", rule, err)
 		}
 
-		if endpointsAExists[e.DNSName] != nil {
-			endpointsAExists[e.DNSName].Targets = append(endpointsAExists[e.DNSName].Targets, e.Targets...)
-		} else {
-			endpoints = append(endpoints, e)
-			endpointsAExists[e.DNSName] = e
-		}
+		endpoints = append(endpoints, e)
 	}
+	observeRules(len(originalRules)-unmanaged, unmanaged)
 
 	for _, deleteEndpoint := range append(changes.UpdateOld, changes.Delete...) {
 		for _, target := range deleteEndpoint.Targets {
@@ -146,6 +447,17 @@ func (p *AdguardHomeProvider) ApplyChanges(ctx context.Context, changes *plan.Ch
 		}
 
 		for _, target := range createEndpoint.Targets {
+			if err := validateTarget(createEndpoint.RecordType, target); err != nil {
+				log.Warnf("skipping target %q of %s record %s: %v", target, createEndpoint.RecordType, createEndpoint.DNSName, err)
+				continue
+			}
+			// The rule could already exist on instances which missed the change previously
+			if idx := slices.IndexFunc(endpoints, func(e *endpoint.Endpoint) bool {
+				return e.DNSName == createEndpoint.DNSName && e.RecordType == createEndpoint.RecordType && e.Targets[0] == target
+			}); idx != -1 {
+				endpoints[idx].Labels = createEndpoint.Labels
+				continue
+			}
 			endpoints = append(endpoints, &endpoint.Endpoint{
 				DNSName:    createEndpoint.DNSName,
 				Targets:    endpoint.Targets{target},
@@ -156,30 +468,77 @@ func (p *AdguardHomeProvider) ApplyChanges(ctx context.Context, changes *plan.Ch
 		log.Debugf("add custom rule %s", createEndpoint)
 	}
 
-	// Build resulting rules: first all endpoint rules, then one artificial rule per unique A record domain
+	// Build resulting rules: first all endpoint rules, then one artificial rule per unique domain of A/AAAA records stored as hosts-style rules
+	dnsRewrites := dnsRewriteNames(endpoints)
 	domainSeen := make(map[string]struct{})
 	domainsOrder := make([]string, 0)
 	for _, e := range endpoints {
-		s := endpointToString(e, suffix)
-		resultingRules = append(resultingRules, s)
-		if e.RecordType == endpoint.RecordTypeA {
+		if _, ok := dnsRewrites[e.DNSName]; ok || isDNSRewriteRecord(e) {
+			s := dnsRewriteRuleToString(e)
+			resultingRules = append(resultingRules, ruleOwnershipToString(s, e.Labels, o), s)
+			continue
+		}
+		resultingRules = append(resultingRules, endpointToString(e, o))
+		if isAddressRecord(e) {
 			if _, ok := domainSeen[e.DNSName]; !ok {
 				domainSeen[e.DNSName] = struct{}{}
 				domainsOrder = append(domainsOrder, e.DNSName)
 			}
 		}
 	}
+	for _, rule := range wildcardExceptions(endpoints) {
+		resultingRules = append(resultingRules, ruleOwnershipToString(rule, nil, o), rule)
+	}
 	for _, d := range domainsOrder {
-		resultingRules = append(resultingRules, artificialRuleToString(d, suffix))
+		resultingRules = append(resultingRules, artificialRuleToString(d, o))
 	}
 
-	return p.client.SaveFilteringRules(ctx, resultingRules)
+	return resultingRules, nil
 }
 
 // Records implements Provider, populating a slice of endpoints from
 // AdguardHome local DNS.
-func (p *AdguardHomeProvider) Records(ctx context.Context) ([]*endpoint.Endpoint, error) {
-	resp, err := p.client.GetFilteringRules(ctx)
+func (p *AdguardHomeProvider) Records(ctx context.Context) (ret []*endpoint.Endpoint, err error) {
+	ctx, span := startSpan(ctx, "Records",
+		attribute.String("backend", p.backend),
+		attribute.String("records.source", p.recordsSource),
+	)
+	defer func() {
+		span.SetAttributes(attribute.Int("records.count", len(ret)))
+		endSpan(span, err)
+	}()
+
+	if err := p.conn.get(); err != nil {
+		return nil, err
+	}
+
+	if p.recordsSource != recordsSourceUnion {
+		return p.recordsFrom(ctx, p.client)
+	}
+
+	var errs []error
+	for _, inst := range p.instances() {
+		records, err := p.recordsFrom(ctx, inst.client)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("instance %s: %w", inst.name, err))
+			continue
+		}
+		ret = append(ret, records...)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return mergeEndpoints(ret), nil
+}
+
+// recordsFrom returns records of a single AdguardHome instance.
+func (p *AdguardHomeProvider) recordsFrom(ctx context.Context, c Client) ([]*endpoint.Endpoint, error) {
+	if p.backend == backendRewrites {
+		return p.rewriteRecords(ctx, c)
+	}
+
+	resp, err := c.GetFilteringRules(ctx)
 	if err != nil {
 		log.Errorf("Error %s", err)
 		return nil, err
@@ -190,112 +549,413 @@ func (p *AdguardHomeProvider) Records(ctx context.Context) ([]*endpoint.Endpoint
 		"rules": resp,
 	}).Debugf("retrieved AdguardHome rules")
 
+	_, span := startSpan(ctx, "ruleRecords", attribute.Int("rules.count", len(resp)))
+	ret, err := p.ruleRecords(resp)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeEndpoints(ret), nil
+}
+
+// ruleRecords returns an endpoint per managed rule which matches the domain filter.
+func (p *AdguardHomeProvider) ruleRecords(rules []string) ([]*endpoint.Endpoint, error) {
+	ret, unmanaged, err := p.parseRuleRecords(rules)
+	if err != nil {
+		return nil, err
+	}
+	observeRules(len(rules)-unmanaged, unmanaged)
+
+	return ret, nil
+}
+
+// parseRuleRecords returns records managed by the provider and the number of unmanaged rules.
+func (p *AdguardHomeProvider) parseRuleRecords(rules []string) ([]*endpoint.Endpoint, int, error) {
 	var ret []*endpoint.Endpoint
-	endpointsAExists := make(map[string]*endpoint.Endpoint)
-	suffix := p.getManagedBy()
-	for _, rule := range resp {
-		e, err := parseRule(rule, suffix)
+	parser := newRuleParser(rules, p.owner())
+	unmanaged := 0
+	for _, rule := range rules {
+		e, err := parser.parse(rule)
 		if err != nil {
 			if errors.Is(err, errNotManaged) {
+				unmanaged++
 				continue
 			}
-			if errors.Is(err, errArtificialRecord) {
+			if errors.Is(err, errArtificialRecord) || errors.Is(err, errRewriteOwnership) || errors.Is(err, errRuleOwnership) {
 				continue
 			}
-			return nil, err
+			ruleParseErrorsTotal.Inc()
+			return nil, 0, err
 		}
 
-		if !p.domainFilter.Match(e.DNSName) {
+		if !p.domainFilter.Match(e.DNSName) || !p.recordTypeEnabled(e.RecordType) {
 			continue
 		}
-		if endpointsAExists[e.DNSName] != nil {
-			endpointsAExists[e.DNSName].Targets = append(endpointsAExists[e.DNSName].Targets, e.Targets...)
-		} else {
+		ret = append(ret, e)
+	}
+
+	return ret, unmanaged, nil
+}
+
+// mergeEndpoints merges targets of endpoints sharing the same name and record type, keeping the original order.
+// Duplicate targets, e.g. the same record read from several instances, are reported once.
+func mergeEndpoints(endpoints []*endpoint.Endpoint) []*endpoint.Endpoint {
+	var ret []*endpoint.Endpoint
+	endpointsExists := make(map[recordKey]*endpoint.Endpoint)
+	for _, e := range endpoints {
+		key := recordKey{dnsName: e.DNSName, recordType: e.RecordType}
+		existing := endpointsExists[key]
+		if existing == nil {
 			ret = append(ret, e)
-			endpointsAExists[e.DNSName] = e
+			endpointsExists[key] = e
+			continue
+		}
+
+		for _, target := range e.Targets {
+			if !slices.Contains(existing.Targets, target) {
+				existing.Targets = append(existing.Targets, target)
+			}
 		}
 	}
 
-	return ret, nil
+	return ret
+}
+
+// filterChanges returns changes containing only endpoints matching fn.
+func filterChanges(changes *plan.Changes, fn func(e *endpoint.Endpoint) bool) *plan.Changes {
+	filter := func(endpoints []*endpoint.Endpoint) []*endpoint.Endpoint {
+		ret := make([]*endpoint.Endpoint, 0, len(endpoints))
+		for _, e := range endpoints {
+			if fn(e) {
+				ret = append(ret, e)
+			}
+		}
+		return ret
+	}
+
+	return &plan.Changes{
+		Create:    filter(changes.Create),
+		UpdateOld: filter(changes.UpdateOld),
+		UpdateNew: filter(changes.UpdateNew),
+		Delete:    filter(changes.Delete),
+	}
+}
+
+// recordKey identifies a record set, targets of rules sharing the same key are merged into a single endpoint.
+type recordKey struct {
+	dnsName    string
+	recordType string
 }
 
 // endpointSupported returns true if the endpoint is supported by the provider
-// it is only possible to store A and TXT records in AdguardHome
+// it is only possible to store A, AAAA, TXT and records supported by $dnsrewrite in AdguardHome
 func endpointSupported(e *endpoint.Endpoint) bool {
-	return e.RecordType == endpoint.RecordTypeA || e.RecordType == endpoint.RecordTypeTXT
+	return isAddressRecord(e) || isDNSRewriteRecord(e) || e.RecordType == endpoint.RecordTypeTXT
 }
 
-func parseRule(rule, suffix string) (*endpoint.Endpoint, error) {
-	if !strings.Contains(rule, suffix) {
+// recordTypeEnabled returns true if records of the type are managed by the provider
+func (p *AdguardHomeProvider) recordTypeEnabled(recordType string) bool {
+	if len(p.recordTypes) == 0 {
+		return true
+	}
+	_, ok := p.recordTypes[recordType]
+	return ok
+}
+
+// isAddressRecord returns true for records stored as hosts-style rules
+func isAddressRecord(e *endpoint.Endpoint) bool {
+	return e.RecordType == endpoint.RecordTypeA || e.RecordType == endpoint.RecordTypeAAAA
+}
+
+// isDNSRewriteRecord returns true for records stored as $dnsrewrite rules
+func isDNSRewriteRecord(e *endpoint.Endpoint) bool {
+	_, ok := dnsRewriteRecordTypes[e.RecordType]
+	return ok || isAddressRecord(e) && isWildcard(e.DNSName)
+}
+
+// dnsRewriteNames returns names of records stored as $dnsrewrite rules other than address records.
+// AdguardHome answers queries of names matching $dnsrewrite rules from these rules only, e.g. an A query of a name with
+// an MX record gets no answer, so address records of these names are stored as $dnsrewrite rules as well.
+func dnsRewriteNames(endpoints []*endpoint.Endpoint) map[string]struct{} {
+	names := make(map[string]struct{})
+	for _, e := range endpoints {
+		if isDNSRewriteRecord(e) && !isAddressRecord(e) {
+			names[e.DNSName] = struct{}{}
+		}
+	}
+	return names
+}
+
+// wildcardExceptions returns exceptions disabling $dnsrewrite rules of wildcard records for other names they match.
+// Like in DNS, wildcards don't answer queries of names with records of their own, while AdguardHome would merge answers
+// of the wildcard into answers of the name and prefer them over hosts-style rules of the name.
+func wildcardExceptions(endpoints []*endpoint.Endpoint) []string {
+	var names []string
+	seen := make(map[string]struct{})
+	for _, e := range endpoints {
+		// TXT records are stored as comments and are not served
+		if _, ok := seen[e.DNSName]; !ok && e.RecordType != endpoint.RecordTypeTXT {
+			seen[e.DNSName] = struct{}{}
+			names = append(names, e.DNSName)
+		}
+	}
+
+	var ret []string
+	seen = make(map[string]struct{})
+	for _, w := range endpoints {
+		if !isWildcard(w.DNSName) || !isDNSRewriteRecord(w) {
+			continue
+		}
+		for _, name := range names {
+			if name == w.DNSName || !strings.HasSuffix(name, w.DNSName[1:]) {
+				continue
+			}
+			rule := "@@" + dnsRewriteRuleToString(&endpoint.Endpoint{DNSName: name, RecordType: w.RecordType, Targets: w.Targets})
+			if _, ok := seen[rule]; !ok {
+				seen[rule] = struct{}{}
+				ret = append(ret, rule)
+			}
+		}
+	}
+	return ret
+}
+
+// isWildcard returns true for names matching subdomains only, e.g. *.apps.example.com.
+// Hosts-style rules don't support wildcards, so address records of wildcard names are stored as $dnsrewrite rules,
+// the `|*.apps.example.com^` pattern doesn't match apps.example.com itself.
+func isWildcard(name string) bool {
+	return strings.HasPrefix(name, "*.")
+}
+
+func parseRule(rule string, o owner) (*endpoint.Endpoint, error) {
+	body, m, err := parseMarker(rule)
+	if err != nil || !o.owns(m) {
 		return nil, errNotManaged
 	}
 
 	// Ignore artificial rules that we manage and will reconstruct
-	if strings.HasPrefix(rule, "@@||") {
+	if strings.HasPrefix(body, "@@||") {
 		return nil, errArtificialRecord
 	}
 
-	// Extract labels from the rule if present
-	var labels endpoint.Labels
-	ruleWithoutLabels := rule
-	if labelsIdx := strings.Index(rule, ";labels="); labelsIdx != -1 {
-		labelsStart := labelsIdx + len(";labels=")
-		labelsJSON := rule[labelsStart:]
-		labels = make(endpoint.Labels)
-		if err := json.Unmarshal([]byte(labelsJSON), &labels); err != nil {
-			log.Warnf("failed to parse labels from rule %s: %v", rule, err)
-			labels = nil
-		}
-		ruleWithoutLabels = rule[:labelsIdx]
+	// Ownership entries of DNS rewrites are handled by the rewrites backend
+	if strings.HasPrefix(body, rewriteOwnershipPrefix) {
+		return nil, errRewriteOwnership
 	}
 
-	if strings.HasPrefix(rule, "#") {
-		r := &endpoint.Endpoint{
-			RecordType: endpoint.RecordTypeTXT,
-			Labels:     labels,
-		}
-		parts := strings.SplitN(ruleWithoutLabels, " ", 4)
-		if len(parts) != 4 {
+	// Owned $dnsrewrite rules are parsed by ruleParser
+	if strings.HasPrefix(body, ruleOwnershipPrefix) {
+		return nil, errRuleOwnership
+	}
+
+	// $dnsrewrite rules with a marker are written by previous versions, they are rewritten without it by the next change
+	if strings.HasPrefix(body, "|") {
+		return parseDNSRewriteRule(rule, body, m.labels)
+	}
+
+	if strings.HasPrefix(body, "#") {
+		parts := strings.SplitN(body, " ", 3)
+		if len(parts) != 3 {
 			return nil, fmt.Errorf("invalid rule: %s", rule)
 		}
-		r.DNSName = parts[2]
-		r.Targets = endpoint.Targets{strings.ReplaceAll(parts[1], suffix, "")}
 
-		return r, nil
+		return &endpoint.Endpoint{
+			RecordType: endpoint.RecordTypeTXT,
+			DNSName:    parts[2],
+			Targets:    endpoint.Targets{parts[1]},
+			Labels:     m.labels,
+		}, nil
 	}
 
-	parts := strings.SplitN(ruleWithoutLabels, " ", 3)
-	if len(parts) != 3 {
+	parts := strings.Fields(body)
+	if len(parts) != 2 {
 		return nil, fmt.Errorf("invalid rule: %s", rule)
 	}
 
+	recordType := endpoint.RecordTypeA
+	if addr, err := netip.ParseAddr(parts[0]); err == nil && addr.Is6() && !addr.Is4In6() {
+		recordType = endpoint.RecordTypeAAAA
+	}
+
 	r := &endpoint.Endpoint{
-		RecordType: endpoint.RecordTypeA,
+		RecordType: recordType,
 		DNSName:    parts[1],
 		Targets:    endpoint.Targets{parts[0]},
+		Labels:     m.labels,
+	}
+
+	return r, nil
+}
+
+// ruleParser parses rules of a filtering rules list. Unlike parseRule, it recognizes $dnsrewrite rules owned through
+// ownership rules of the same list. Every ownership rule owns a single copy of its rule: several owners and hand-written
+// rules may hold identical copies, the n-th copy belongs to the n-th ownership rule of any owner and remaining copies
+// are not managed. Rules have to be parsed once each in the order of the list.
+type ruleParser struct {
+	owner owner
+	// owners lists ownership rules of every $dnsrewrite rule in the order of the list
+	owners map[string][]ruleOwnership
+	// seen counts copies of $dnsrewrite rules parsed so far
+	seen map[string]int
+}
+
+// ruleOwnership is an ownership rule of a $dnsrewrite rule
+type ruleOwnership struct {
+	owned  bool
+	labels endpoint.Labels
+}
+
+func newRuleParser(rules []string, o owner) *ruleParser {
+	r := &ruleParser{owner: o, owners: make(map[string][]ruleOwnership), seen: make(map[string]int)}
+	for _, rule := range rules {
+		owned, m, err := parseRuleOwnership(rule)
+		if err != nil {
+			continue
+		}
+		r.owners[owned] = append(r.owners[owned], ruleOwnership{owned: o.owns(m), labels: m.labels})
+	}
+	return r
+}
+
+// parse returns the record of rule, owned copies of $dnsrewrite rules without a marker are parsed as records of the provider.
+func (r *ruleParser) parse(rule string) (*endpoint.Endpoint, error) {
+	if owners, ok := r.owners[rule]; ok {
+		i := r.seen[rule]
+		r.seen[rule]++
+		if i < len(owners) && owners[i].owned {
+			// Exceptions of wildcard records are reconstructed like artificial rules
+			if strings.HasPrefix(rule, "@@") {
+				return nil, errArtificialRecord
+			}
+			return parseDNSRewriteRule(rule, rule, owners[i].labels)
+		}
+	}
+	return parseRule(rule, r.owner)
+}
+
+func ruleOwnershipToString(rule string, labels endpoint.Labels, o owner) string {
+	return o.mark(ruleOwnershipPrefix+rule, labels)
+}
+
+// parseRuleOwnership returns the rule owned according to the ownership rule of any owner and the marker of the owner.
+func parseRuleOwnership(rule string) (string, marker, error) {
+	body, m, err := parseMarker(rule)
+	if err != nil || !strings.HasPrefix(body, ruleOwnershipPrefix) {
+		return "", marker{}, errNotManaged
+	}
+	return strings.TrimPrefix(body, ruleOwnershipPrefix), m, nil
+}
+
+// parseDNSRewriteRule parses body of rules in the `|name^$dnsrewrite=NOERROR;TYPE;value` format,
+// previous versions wrote `||name^`, which matches subdomains as well.
+func parseDNSRewriteRule(rule, body string, labels endpoint.Labels) (*endpoint.Endpoint, error) {
+	name, value, ok := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(body, "|"), "|"), dnsRewriteModifier)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("invalid rule: %s", rule)
+	}
+
+	parts := strings.SplitN(value, ";", 3)
+	if len(parts) != 3 || parts[0] != dnsRewriteRCode || parts[2] == "" {
+		return nil, fmt.Errorf("invalid rule: %s", rule)
+	}
+
+	r := &endpoint.Endpoint{
+		RecordType: parts[1],
+		DNSName:    name,
+		Targets:    endpoint.Targets{parts[2]},
 		Labels:     labels,
 	}
+	if !isDNSRewriteRecord(r) && !isAddressRecord(r) {
+		return nil, fmt.Errorf("unsupported record type %s in rule: %s", parts[1], rule)
+	}
+	if err := validateTarget(r.RecordType, parts[2]); err != nil {
+		return nil, fmt.Errorf("invalid rule %s: %w", rule, err)
+	}
 
 	return r, nil
 }
 
-func endpointToString(e *endpoint.Endpoint, suffix string) string {
-	// Serialize labels if present
-	labelsSuffix := ""
-	if len(e.Labels) > 0 {
-		labelsJSON, err := json.Marshal(e.Labels)
-		if err == nil {
-			labelsSuffix = fmt.Sprintf(";labels=%s", string(labelsJSON))
+// validateTarget checks that the target has the format expected by $dnsrewrite for the given record type:
+// MX targets are "priority host", SRV targets are "priority weight port host", A and AAAA targets are IP addresses.
+func validateTarget(recordType, target string) error {
+	fields := strings.Fields(target)
+
+	switch recordType {
+	case endpoint.RecordTypeMX:
+		if len(fields) != 2 {
+			return fmt.Errorf("expected MX target in the \"priority host\" format, got %q", target)
+		}
+		if err := validateUint16("priority", fields[0]); err != nil {
+			return err
+		}
+	case endpoint.RecordTypeSRV:
+		if len(fields) != 4 {
+			return fmt.Errorf("expected SRV target in the \"priority weight port host\" format, got %q", target)
+		}
+		for i, name := range []string{"priority", "weight", "port"} {
+			if err := validateUint16(name, fields[i]); err != nil {
+				return err
+			}
+		}
+	case endpoint.RecordTypeA, endpoint.RecordTypeAAAA:
+		addr, err := netip.ParseAddr(target)
+		if err != nil {
+			return fmt.Errorf("expected an IP address, got %q", target)
+		}
+		if isIPv6 := addr.Is6() && !addr.Is4In6(); isIPv6 != (recordType == endpoint.RecordTypeAAAA) {
+			return fmt.Errorf("unexpected IP address %q for %s record", target, recordType)
+		}
+	case endpoint.RecordTypeCNAME, endpoint.RecordTypePTR:
+		if len(fields) != 1 {
+			return fmt.Errorf("expected a single host name, got %q", target)
 		}
 	}
 
+	return nil
+}
+
+func validateUint16(name, value string) error {
+	if _, err := strconv.ParseUint(value, 10, 16); err != nil {
+		return fmt.Errorf("invalid %s %q: must be a number between 0 and 65535", name, value)
+	}
+	return nil
+}
+
+// labelsToString serializes labels to be appended to the managed-by marker of a rule.
+func labelsToString(labels endpoint.Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	labelsJSON, err := json.Marshal(labels)
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf(";labels=%s", string(labelsJSON))
+}
+
+// endpointToString returns the rule of a record. $dnsrewrite rules are returned without a marker,
+// they are owned through the rule returned by ruleOwnershipToString.
+func endpointToString(e *endpoint.Endpoint, o owner) string {
 	if e.RecordType == endpoint.RecordTypeTXT {
-		return fmt.Sprintf("# %s %s %s%s", e.Targets[0], e.DNSName, suffix, labelsSuffix)
+		return o.mark(fmt.Sprintf("# %s %s", e.Targets[0], e.DNSName), e.Labels)
 	}
 
-	return fmt.Sprintf("%s %s #%s%s", e.Targets[0], e.DNSName, suffix, labelsSuffix)
+	if isDNSRewriteRecord(e) {
+		return dnsRewriteRuleToString(e)
+	}
+
+	return o.mark(fmt.Sprintf("%s %s", e.Targets[0], e.DNSName), e.Labels)
+}
+
+// dnsRewriteRuleToString returns the $dnsrewrite rule of a record.
+// `|name^` matches the name only, `||name^` would answer queries of subdomains as well.
+func dnsRewriteRuleToString(e *endpoint.Endpoint) string {
+	return fmt.Sprintf("|%s%s%s;%s;%s", e.DNSName, dnsRewriteModifier, dnsRewriteRCode, e.RecordType, e.Targets[0])
 }
 
-func artificialRuleToString(domain, suffix string) string {
-	return fmt.Sprintf("@@||%s #%s", domain, suffix)
+func artificialRuleToString(domain string, o owner) string {
+	return o.mark("@@||"+domain, nil)
 }
@@ -2,26 +2,68 @@ package adguardhome
 
 import (
 	"context"
-	"os"
+	"errors"
+	"fmt"
+	"net/netip"
+	"path"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"sigs.k8s.io/external-dns/endpoint"
 	"sigs.k8s.io/external-dns/plan"
 	"sigs.k8s.io/external-dns/provider"
 )
 
 type mockAdguardClient struct {
-	rules []string
+	rules    []string
+	rewrites []RewriteEntry
+	// err is returned by filtering rules calls when set
+	err error
+	// saves counts saved filtering rules
+	saves int
 }
 
 func (m *mockAdguardClient) GetFilteringRules(_ context.Context) ([]string, error) {
-	return m.rules, nil
+	return m.rules, m.err
 }
 
 func (m *mockAdguardClient) SaveFilteringRules(_ context.Context, rules []string) error {
+	if m.err != nil {
+		return m.err
+	}
 	m.rules = rules
+	m.saves++
+	return nil
+}
+
+func (m *mockAdguardClient) Health(_ context.Context) error {
+	return m.err
+}
+
+func (m *mockAdguardClient) ListRewrites(_ context.Context) ([]RewriteEntry, error) {
+	return m.rewrites, nil
+}
+
+func (m *mockAdguardClient) AddRewrite(_ context.Context, entry RewriteEntry) error {
+	m.rewrites = append(m.rewrites, entry)
+	return nil
+}
+
+func (m *mockAdguardClient) DeleteRewrite(_ context.Context, entry RewriteEntry) error {
+	m.rewrites = slices.DeleteFunc(m.rewrites, func(e RewriteEntry) bool {
+		return e == entry
+	})
+	return nil
+}
+
+// UpdateRewrite changes the first matching rewrite only, like AdguardHome
+func (m *mockAdguardClient) UpdateRewrite(_ context.Context, target, update RewriteEntry) error {
+	if i := slices.Index(m.rewrites, target); i != -1 {
+		m.rewrites[i] = update
+	}
 	return nil
 }
 
@@ -266,7 +308,7 @@ func TestAdguardHomeProvider_Records(t *testing.T) {
 }
 
 func TestNewAdguardHomeProvider(t *testing.T) {
-	got, err := NewAdguardHomeProvider(true)
+	got, err := NewAdguardHomeProvider(&Config{DryRun: true})
 	if err == nil {
 		t.Errorf("NewAdguardHomeProvider() error = %v", err)
 		return
@@ -276,13 +318,31 @@ func TestNewAdguardHomeProvider(t *testing.T) {
 		t.Errorf("NewAdguardHomeProvider() = %v, want %v", got, "not nil")
 	}
 
-	_ = os.Setenv(envURL, "http://localhost:3000")
-	_ = os.Setenv(envUser, "pw")
-	_ = os.Setenv(envPassword, "user")
+	user, pass := "user", "pw"
+	srv := newTestServer(t, &user, &pass)
 
-	_, err = NewAdguardHomeProvider(true)
+	t.Setenv(envURL, srv.URL)
+	t.Setenv(envUser, user)
+	t.Setenv(envPassword, pass)
+
+	cfg, err := LoadConfig("")
 	if err != nil {
-		t.Errorf("NewAdguardHomeProvider() error = %v", err)
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	cfg.DryRun = true
+
+	p, err := NewAdguardHomeProvider(cfg)
+	if err != nil {
+		t.Fatalf("NewAdguardHomeProvider() error = %v", err)
+	}
+
+	// Dry run reads live records
+	records, err := p.Records(context.Background())
+	if err != nil {
+		t.Fatalf("Records() error = %v", err)
+	}
+	if len(records) != 1 {
+		t.Errorf("expected records of AdguardHome, got %v", records)
 	}
 }
 
@@ -468,3 +528,853 @@ func TestAdguardHomeProvider_LabelsHandling(t *testing.T) {
 		t.Errorf("labels not preserved after update: got %v, expected %v", updatedRecord.Labels, labelsMap)
 	}
 }
+
+func TestAdguardHomeProvider_AAAARecords(t *testing.T) {
+	c := newMockClient()
+	p := &AdguardHomeProvider{
+		client: c,
+	}
+	c.rules = []string{
+		"1.1.1.1 example.com #$managed by external-dns",
+	}
+
+	changes := &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			{
+				DNSName:    "example.com",
+				RecordType: endpoint.RecordTypeAAAA,
+				Targets:    endpoint.Targets{"2001:db8::1", "2001:db8::2"},
+			},
+			{
+				DNSName:    "v6only.example.com",
+				RecordType: endpoint.RecordTypeAAAA,
+				Targets:    endpoint.Targets{"2001:db8::3"},
+			},
+		},
+	}
+
+	err := p.ApplyChanges(context.Background(), changes)
+	if err != nil {
+		t.Errorf("failed to apply changes: %v", err)
+	}
+
+	expectedRules := []string{
+		"1.1.1.1 example.com #$managed by external-dns",
+		"2001:db8::1 example.com #$managed by external-dns",
+		"2001:db8::2 example.com #$managed by external-dns",
+		"2001:db8::3 v6only.example.com #$managed by external-dns",
+		"@@||example.com #$managed by external-dns",
+		"@@||v6only.example.com #$managed by external-dns",
+	}
+	if !reflect.DeepEqual(c.rules, expectedRules) {
+		t.Errorf("rules do not match: got: %v, expected: %v", c.rules, expectedRules)
+	}
+
+	got, err := p.Records(context.Background())
+	if err != nil {
+		t.Errorf("failed to fetch records: %v", err)
+	}
+
+	expected := []*endpoint.Endpoint{
+		{
+			DNSName:    "example.com",
+			RecordType: endpoint.RecordTypeA,
+			Targets:    endpoint.Targets{"1.1.1.1"},
+		},
+		{
+			DNSName:    "example.com",
+			RecordType: endpoint.RecordTypeAAAA,
+			Targets:    endpoint.Targets{"2001:db8::1", "2001:db8::2"},
+		},
+		{
+			DNSName:    "v6only.example.com",
+			RecordType: endpoint.RecordTypeAAAA,
+			Targets:    endpoint.Targets{"2001:db8::3"},
+		},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("records do not match: got: %v, expected: %v", got, expected)
+	}
+
+	// Deleting a single target keeps the rest of the record set
+	changes = &plan.Changes{
+		Delete: []*endpoint.Endpoint{
+			{
+				DNSName:    "example.com",
+				RecordType: endpoint.RecordTypeAAAA,
+				Targets:    endpoint.Targets{"2001:db8::1"},
+			},
+		},
+	}
+
+	err = p.ApplyChanges(context.Background(), changes)
+	if err != nil {
+		t.Errorf("failed to apply changes: %v", err)
+	}
+
+	expectedRules = []string{
+		"1.1.1.1 example.com #$managed by external-dns",
+		"2001:db8::2 example.com #$managed by external-dns",
+		"2001:db8::3 v6only.example.com #$managed by external-dns",
+		"@@||example.com #$managed by external-dns",
+		"@@||v6only.example.com #$managed by external-dns",
+	}
+	if !reflect.DeepEqual(c.rules, expectedRules) {
+		t.Errorf("rules do not match: got: %v, expected: %v", c.rules, expectedRules)
+	}
+}
+
+func TestAdguardHomeProvider_CNAMERecords(t *testing.T) {
+	c := newMockClient()
+	p := &AdguardHomeProvider{
+		client: c,
+	}
+	c.rules = []string{
+		"# I am not for external-dns",
+	}
+
+	labelsMap := endpoint.Labels{
+		"owner": "test-owner",
+	}
+
+	changes := &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			{
+				DNSName:    "app.example.com",
+				RecordType: endpoint.RecordTypeCNAME,
+				Targets:    endpoint.Targets{"lb.example.net"},
+				Labels:     labelsMap,
+			},
+		},
+	}
+
+	err := p.ApplyChanges(context.Background(), changes)
+	if err != nil {
+		t.Errorf("failed to apply changes: %v", err)
+	}
+
+	expectedRules := []string{
+		"# I am not for external-dns",
+		`! rule |app.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net #$managed by external-dns;labels={"owner":"test-owner"}`,
+		"|app.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net",
+	}
+	if !reflect.DeepEqual(c.rules, expectedRules) {
+		t.Errorf("rules do not match: got: %v, expected: %v", c.rules, expectedRules)
+	}
+
+	got, err := p.Records(context.Background())
+	if err != nil {
+		t.Errorf("failed to fetch records: %v", err)
+	}
+
+	expected := []*endpoint.Endpoint{
+		{
+			DNSName:    "app.example.com",
+			RecordType: endpoint.RecordTypeCNAME,
+			Targets:    endpoint.Targets{"lb.example.net"},
+			Labels:     labelsMap,
+		},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("records do not match: got: %v, expected: %v", got, expected)
+	}
+
+	changes = &plan.Changes{
+		UpdateOld: expected,
+		UpdateNew: []*endpoint.Endpoint{
+			{
+				DNSName:    "app.example.com",
+				RecordType: endpoint.RecordTypeCNAME,
+				Targets:    endpoint.Targets{"lb2.example.net"},
+			},
+		},
+	}
+
+	err = p.ApplyChanges(context.Background(), changes)
+	if err != nil {
+		t.Errorf("failed to apply changes: %v", err)
+	}
+
+	expectedRules = []string{
+		"# I am not for external-dns",
+		"! rule |app.example.com^$dnsrewrite=NOERROR;CNAME;lb2.example.net #$managed by external-dns",
+		"|app.example.com^$dnsrewrite=NOERROR;CNAME;lb2.example.net",
+	}
+	if !reflect.DeepEqual(c.rules, expectedRules) {
+		t.Errorf("rules do not match: got: %v, expected: %v", c.rules, expectedRules)
+	}
+}
+
+func TestAdguardHomeProvider_WildcardRecords(t *testing.T) {
+	c := &mockAdguardClient{rules: []string{"# I am not for external-dns"}}
+	p := &AdguardHomeProvider{client: c}
+
+	changes := &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			{DNSName: "apps.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"1.1.1.1"}},
+			{DNSName: "*.apps.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"2.2.2.2"}},
+			{DNSName: "*.apps.example.com", RecordType: endpoint.RecordTypeAAAA, Targets: endpoint.Targets{"2001:db8::2"}},
+			{DNSName: "db.apps.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"3.3.3.3"}},
+			{DNSName: "*.apps.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"not-an-ip"}},
+		},
+	}
+
+	err := p.ApplyChanges(context.Background(), changes)
+	if err != nil {
+		t.Fatalf("failed to apply changes: %v", err)
+	}
+
+	// Wildcards only match subdomains and get no artificial rule, names with records of their own get exceptions
+	// of the wildcard rules
+	expectedRules := []string{
+		"# I am not for external-dns",
+		"1.1.1.1 apps.example.com #$managed by external-dns",
+		"! rule |*.apps.example.com^$dnsrewrite=NOERROR;A;2.2.2.2 #$managed by external-dns",
+		"|*.apps.example.com^$dnsrewrite=NOERROR;A;2.2.2.2",
+		"! rule |*.apps.example.com^$dnsrewrite=NOERROR;AAAA;2001:db8::2 #$managed by external-dns",
+		"|*.apps.example.com^$dnsrewrite=NOERROR;AAAA;2001:db8::2",
+		"3.3.3.3 db.apps.example.com #$managed by external-dns",
+		"! rule @@|db.apps.example.com^$dnsrewrite=NOERROR;A;2.2.2.2 #$managed by external-dns",
+		"@@|db.apps.example.com^$dnsrewrite=NOERROR;A;2.2.2.2",
+		"! rule @@|db.apps.example.com^$dnsrewrite=NOERROR;AAAA;2001:db8::2 #$managed by external-dns",
+		"@@|db.apps.example.com^$dnsrewrite=NOERROR;AAAA;2001:db8::2",
+		"@@||apps.example.com #$managed by external-dns",
+		"@@||db.apps.example.com #$managed by external-dns",
+	}
+	if !reflect.DeepEqual(c.rules, expectedRules) {
+		t.Errorf("rules do not match: got: %v, expected: %v", c.rules, expectedRules)
+	}
+
+	queries := []struct {
+		name       string
+		recordType string
+		expected   []string
+	}{
+		{"apps.example.com", endpoint.RecordTypeA, []string{"1.1.1.1"}},
+		{"web.apps.example.com", endpoint.RecordTypeA, []string{"2.2.2.2"}},
+		{"web.apps.example.com", endpoint.RecordTypeAAAA, []string{"2001:db8::2"}},
+		{"db.apps.example.com", endpoint.RecordTypeA, []string{"3.3.3.3"}},
+		{"db.apps.example.com", endpoint.RecordTypeAAAA, nil},
+	}
+	for _, q := range queries {
+		if got := resolve(c.rules, q.name, q.recordType); !reflect.DeepEqual(got, q.expected) {
+			t.Errorf("%s %s resolves to %v, expected %v", q.recordType, q.name, got, q.expected)
+		}
+	}
+	if report := (LintReport{Rules: p.lintRules(c.rules)}); report.Problems() != 0 {
+		t.Errorf("expected no lint problems, got %+v", report.Rules)
+	}
+
+	got, err := p.Records(context.Background())
+	if err != nil {
+		t.Fatalf("failed to fetch records: %v", err)
+	}
+
+	expected := []*endpoint.Endpoint{
+		{DNSName: "apps.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"1.1.1.1"}},
+		{DNSName: "*.apps.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"2.2.2.2"}},
+		{DNSName: "*.apps.example.com", RecordType: endpoint.RecordTypeAAAA, Targets: endpoint.Targets{"2001:db8::2"}},
+		{DNSName: "db.apps.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"3.3.3.3"}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("records do not match: got: %v, expected: %v", got, expected)
+	}
+
+	// Deleting the wildcard keeps records of overlapping exact names
+	err = p.ApplyChanges(context.Background(), &plan.Changes{Delete: expected[1:3]})
+	if err != nil {
+		t.Fatalf("failed to apply changes: %v", err)
+	}
+
+	expectedRules = []string{
+		"# I am not for external-dns",
+		"1.1.1.1 apps.example.com #$managed by external-dns",
+		"3.3.3.3 db.apps.example.com #$managed by external-dns",
+		"@@||apps.example.com #$managed by external-dns",
+		"@@||db.apps.example.com #$managed by external-dns",
+	}
+	if !reflect.DeepEqual(c.rules, expectedRules) {
+		t.Errorf("rules do not match: got: %v, expected: %v", c.rules, expectedRules)
+	}
+}
+
+func TestAdguardHomeProvider_DNSRewriteOwnership(t *testing.T) {
+	c := &mockAdguardClient{rules: []string{
+		"|manual.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net",
+		"! rule |other.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net #$managed by external-dns;ref:other",
+		"|other.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net",
+		// Written by previous versions with the marker in the rule and matching subdomains
+		"||legacy.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net #$managed by external-dns",
+		// The rule was removed by hand, so the record doesn't exist
+		"! rule |gone.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net #$managed by external-dns",
+	}}
+	p := &AdguardHomeProvider{client: c}
+
+	got, err := p.Records(context.Background())
+	if err != nil {
+		t.Fatalf("failed to fetch records: %v", err)
+	}
+	expected := []*endpoint.Endpoint{
+		{DNSName: "legacy.example.com", RecordType: endpoint.RecordTypeCNAME, Targets: endpoint.Targets{"lb.example.net"}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("records do not match: got: %v, expected: %v", got, expected)
+	}
+
+	err = p.ApplyChanges(context.Background(), &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			{DNSName: "gone.example.com", RecordType: endpoint.RecordTypeCNAME, Targets: endpoint.Targets{"lb.example.net"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to apply changes: %v", err)
+	}
+
+	expectedRules := []string{
+		"|manual.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net",
+		"! rule |other.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net #$managed by external-dns;ref:other",
+		"|other.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net",
+		"! rule |legacy.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net #$managed by external-dns",
+		"|legacy.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net",
+		"! rule |gone.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net #$managed by external-dns",
+		"|gone.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net",
+	}
+	if !reflect.DeepEqual(c.rules, expectedRules) {
+		t.Errorf("rules do not match: got: %v, expected: %v", c.rules, expectedRules)
+	}
+}
+
+func TestAdguardHomeProvider_DNSRewriteOwners(t *testing.T) {
+	rule := "|app.example.com^$dnsrewrite=NOERROR;CNAME;lb.example.net"
+	c := &mockAdguardClient{rules: []string{rule}}
+	a := &AdguardHomeProvider{client: c, managedBySuffix: "a"}
+	b := &AdguardHomeProvider{client: c, managedBySuffix: "b"}
+	record := &endpoint.Endpoint{DNSName: "app.example.com", RecordType: endpoint.RecordTypeCNAME, Targets: endpoint.Targets{"lb.example.net"}}
+
+	records := func(p *AdguardHomeProvider) int {
+		t.Helper()
+		got, err := p.Records(context.Background())
+		if err != nil {
+			t.Fatalf("failed to fetch records: %v", err)
+		}
+		return len(got)
+	}
+
+	// The hand-written rule is not adopted, every owner writes a copy of its own
+	for _, p := range []*AdguardHomeProvider{a, b} {
+		if n := records(p); n != 0 {
+			t.Fatalf("expected no records of %s before the create, got %d", p.managedBySuffix, n)
+		}
+		if err := p.ApplyChanges(context.Background(), &plan.Changes{Create: []*endpoint.Endpoint{record}}); err != nil {
+			t.Fatalf("failed to apply changes: %v", err)
+		}
+	}
+	expectedRules := []string{
+		rule,
+		"! rule " + rule + " #$managed by external-dns;ref:a",
+		rule,
+		"! rule " + rule + " #$managed by external-dns;ref:b",
+		rule,
+	}
+	if !reflect.DeepEqual(c.rules, expectedRules) {
+		t.Errorf("rules do not match: got: %v, expected: %v", c.rules, expectedRules)
+	}
+
+	// Deleting the record of a keeps the record of b and the hand-written rule
+	if err := a.ApplyChanges(context.Background(), &plan.Changes{Delete: []*endpoint.Endpoint{record}}); err != nil {
+		t.Fatalf("failed to apply changes: %v", err)
+	}
+	if n := records(a); n != 0 {
+		t.Errorf("expected no records of a after the delete, got %d", n)
+	}
+	if n := records(b); n != 1 {
+		t.Errorf("expected the record of b to be kept, got %d records", n)
+	}
+
+	if err := b.ApplyChanges(context.Background(), &plan.Changes{Delete: []*endpoint.Endpoint{record}}); err != nil {
+		t.Fatalf("failed to apply changes: %v", err)
+	}
+	if expectedRules := []string{rule}; !reflect.DeepEqual(c.rules, expectedRules) {
+		t.Errorf("rules do not match: got: %v, expected: %v", c.rules, expectedRules)
+	}
+}
+
+// resolve returns answers of AdguardHome to a query of name for rules written by the provider.
+// Answers of all $dnsrewrite rules matching the name are merged and take precedence over hosts-style rules,
+// a matching $dnsrewrite rule without answers of the record type results in an empty answer.
+// `@@` exceptions with the $dnsrewrite modifier disable $dnsrewrite rules with the same value.
+func resolve(rules []string, name, recordType string) []string {
+	var rewrites, exceptions []string
+	for _, rule := range rules {
+		pattern, value, ok := strings.Cut(rule, dnsRewriteModifier)
+		if !ok || strings.HasPrefix(rule, "!") {
+			continue
+		}
+		exception := strings.HasPrefix(pattern, "@@")
+		if !patternMatches(strings.TrimPrefix(pattern, "@@"), name) {
+			continue
+		}
+		if exception {
+			exceptions = append(exceptions, value)
+		} else {
+			rewrites = append(rewrites, value)
+		}
+	}
+	rewrites = slices.DeleteFunc(rewrites, func(v string) bool { return slices.Contains(exceptions, v) })
+
+	var answers []string
+	if len(rewrites) > 0 {
+		for _, v := range rewrites {
+			if parts := strings.SplitN(v, ";", 3); parts[1] == recordType {
+				answers = append(answers, parts[2])
+			}
+		}
+		return answers
+	}
+
+	for _, rule := range rules {
+		fields := strings.Fields(rule)
+		if len(fields) < 2 || fields[1] != name {
+			continue
+		}
+		addr, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			continue
+		}
+		t := endpoint.RecordTypeA
+		if addr.Is6() {
+			t = endpoint.RecordTypeAAAA
+		}
+		if t == recordType {
+			answers = append(answers, fields[0])
+		}
+	}
+	return answers
+}
+
+// patternMatches returns true when the pattern of a rule matches name: `|pattern` matches the name only,
+// `||pattern` matches subdomains as well and `*` matches any characters.
+func patternMatches(pattern, name string) bool {
+	if p, ok := strings.CutPrefix(pattern, "||"); ok {
+		for host := name; ; {
+			if matched, _ := path.Match(p, host); matched {
+				return true
+			}
+			_, parent, ok := strings.Cut(host, ".")
+			if !ok {
+				return false
+			}
+			host = parent
+		}
+	}
+	matched, _ := path.Match(strings.TrimPrefix(pattern, "|"), name)
+	return matched
+}
+
+func TestAdguardHomeProvider_DNSRewriteOverlap(t *testing.T) {
+	c := &mockAdguardClient{}
+	p := &AdguardHomeProvider{client: c}
+
+	mx := &endpoint.Endpoint{DNSName: "example.com", RecordType: endpoint.RecordTypeMX, Targets: endpoint.Targets{"10 mail.example.com"}}
+	err := p.ApplyChanges(context.Background(), &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			{DNSName: "example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"1.1.1.1"}},
+			mx,
+			{DNSName: "www.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"2.2.2.2"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to apply changes: %v", err)
+	}
+
+	// The A record shares the name of the MX record, so it is stored as a $dnsrewrite rule as well
+	expectedRules := []string{
+		"! rule |example.com^$dnsrewrite=NOERROR;A;1.1.1.1 #$managed by external-dns",
+		"|example.com^$dnsrewrite=NOERROR;A;1.1.1.1",
+		"! rule |example.com^$dnsrewrite=NOERROR;MX;10 mail.example.com #$managed by external-dns",
+		"|example.com^$dnsrewrite=NOERROR;MX;10 mail.example.com",
+		"2.2.2.2 www.example.com #$managed by external-dns",
+		"@@||www.example.com #$managed by external-dns",
+	}
+	if !reflect.DeepEqual(c.rules, expectedRules) {
+		t.Errorf("rules do not match: got: %v, expected: %v", c.rules, expectedRules)
+	}
+
+	queries := []struct {
+		name       string
+		recordType string
+		expected   []string
+	}{
+		{"example.com", endpoint.RecordTypeA, []string{"1.1.1.1"}},
+		{"example.com", endpoint.RecordTypeMX, []string{"10 mail.example.com"}},
+		{"www.example.com", endpoint.RecordTypeA, []string{"2.2.2.2"}},
+		{"www.example.com", endpoint.RecordTypeMX, nil},
+	}
+	for _, q := range queries {
+		if got := resolve(c.rules, q.name, q.recordType); !reflect.DeepEqual(got, q.expected) {
+			t.Errorf("%s %s resolves to %v, expected %v", q.recordType, q.name, got, q.expected)
+		}
+	}
+
+	got, err := p.Records(context.Background())
+	if err != nil {
+		t.Fatalf("failed to fetch records: %v", err)
+	}
+	if len(got) != 3 {
+		t.Errorf("expected 3 records, got %v", got)
+	}
+
+	// Without the MX record the A record is stored as a hosts-style rule again
+	if err := p.ApplyChanges(context.Background(), &plan.Changes{Delete: []*endpoint.Endpoint{mx}}); err != nil {
+		t.Fatalf("failed to apply changes: %v", err)
+	}
+	expectedRules = []string{
+		"1.1.1.1 example.com #$managed by external-dns",
+		"2.2.2.2 www.example.com #$managed by external-dns",
+		"@@||example.com #$managed by external-dns",
+		"@@||www.example.com #$managed by external-dns",
+	}
+	if !reflect.DeepEqual(c.rules, expectedRules) {
+		t.Errorf("rules do not match: got: %v, expected: %v", c.rules, expectedRules)
+	}
+}
+
+func TestParseRule_InvalidDNSRewrite(t *testing.T) {
+	rules := []string{
+		"|app.example.com^$dnsrewrite=NOERROR;CNAME #$managed by external-dns",
+		"|app.example.com^$dnsrewrite=REFUSED;CNAME;lb.example.net #$managed by external-dns",
+		"|app.example.com^$dnsrewrite=NOERROR;NAPTR;lb.example.net #$managed by external-dns",
+		"||app.example.com^ #$managed by external-dns",
+	}
+	for _, rule := range rules {
+		if _, err := parseRule(rule, owner{}); err == nil {
+			t.Errorf("expected error for rule %q", rule)
+		}
+	}
+}
+
+func TestAdguardHomeProvider_DNSRewriteRecordTypes(t *testing.T) {
+	c := newMockClient()
+	p := &AdguardHomeProvider{
+		client: c,
+	}
+	c.rules = []string{}
+
+	changes := &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			{
+				DNSName:    "example.com",
+				RecordType: endpoint.RecordTypeMX,
+				Targets:    endpoint.Targets{"10 mail.example.com", "20 backup.example.com", "high mail.example.com"},
+			},
+			{
+				DNSName:    "_sip._tcp.example.com",
+				RecordType: endpoint.RecordTypeSRV,
+				Targets:    endpoint.Targets{"10 5 5060 sip.example.com", "10 5 70000 sip.example.com"},
+			},
+			{
+				DNSName:    "4.3.2.1.in-addr.arpa",
+				RecordType: endpoint.RecordTypePTR,
+				Targets:    endpoint.Targets{"host.example.com"},
+			},
+		},
+	}
+
+	err := p.ApplyChanges(context.Background(), changes)
+	if err != nil {
+		t.Errorf("failed to apply changes: %v", err)
+	}
+
+	expectedRules := []string{
+		"! rule |example.com^$dnsrewrite=NOERROR;MX;10 mail.example.com #$managed by external-dns",
+		"|example.com^$dnsrewrite=NOERROR;MX;10 mail.example.com",
+		"! rule |example.com^$dnsrewrite=NOERROR;MX;20 backup.example.com #$managed by external-dns",
+		"|example.com^$dnsrewrite=NOERROR;MX;20 backup.example.com",
+		"! rule |_sip._tcp.example.com^$dnsrewrite=NOERROR;SRV;10 5 5060 sip.example.com #$managed by external-dns",
+		"|_sip._tcp.example.com^$dnsrewrite=NOERROR;SRV;10 5 5060 sip.example.com",
+		"! rule |4.3.2.1.in-addr.arpa^$dnsrewrite=NOERROR;PTR;host.example.com #$managed by external-dns",
+		"|4.3.2.1.in-addr.arpa^$dnsrewrite=NOERROR;PTR;host.example.com",
+	}
+	if !reflect.DeepEqual(c.rules, expectedRules) {
+		t.Errorf("rules do not match: got: %v, expected: %v", c.rules, expectedRules)
+	}
+
+	got, err := p.Records(context.Background())
+	if err != nil {
+		t.Errorf("failed to fetch records: %v", err)
+	}
+
+	expected := []*endpoint.Endpoint{
+		{
+			DNSName:    "example.com",
+			RecordType: endpoint.RecordTypeMX,
+			Targets:    endpoint.Targets{"10 mail.example.com", "20 backup.example.com"},
+		},
+		{
+			DNSName:    "_sip._tcp.example.com",
+			RecordType: endpoint.RecordTypeSRV,
+			Targets:    endpoint.Targets{"10 5 5060 sip.example.com"},
+		},
+		{
+			DNSName:    "4.3.2.1.in-addr.arpa",
+			RecordType: endpoint.RecordTypePTR,
+			Targets:    endpoint.Targets{"host.example.com"},
+		},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("records do not match: got: %v, expected: %v", got, expected)
+	}
+}
+
+func TestValidateTarget(t *testing.T) {
+	tests := []struct {
+		recordType string
+		target     string
+		wantErr    bool
+	}{
+		{recordType: endpoint.RecordTypeMX, target: "10 mail.example.com"},
+		{recordType: endpoint.RecordTypeMX, target: "mail.example.com", wantErr: true},
+		{recordType: endpoint.RecordTypeMX, target: "-1 mail.example.com", wantErr: true},
+		{recordType: endpoint.RecordTypeSRV, target: "0 0 443 svc.example.com"},
+		{recordType: endpoint.RecordTypeSRV, target: "0 0 svc.example.com", wantErr: true},
+		{recordType: endpoint.RecordTypeSRV, target: "0 65536 443 svc.example.com", wantErr: true},
+		{recordType: endpoint.RecordTypePTR, target: "host.example.com"},
+		{recordType: endpoint.RecordTypePTR, target: "host example.com", wantErr: true},
+		{recordType: endpoint.RecordTypeA, target: "1.1.1.1"},
+		{recordType: endpoint.RecordTypeA, target: "2001:db8::1", wantErr: true},
+		{recordType: endpoint.RecordTypeAAAA, target: "2001:db8::1"},
+		{recordType: endpoint.RecordTypeAAAA, target: "host.example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.recordType+" "+tt.target, func(t *testing.T) {
+			err := validateTarget(tt.recordType, tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAdguardHomeProvider_ApplyChangesDomainFilter(t *testing.T) {
+	c := newMockClient()
+	p := &AdguardHomeProvider{
+		client:       c,
+		domainFilter: endpoint.NewDomainFilterWithExclusions([]string{"example.com"}, []string{"private.example.com"}),
+	}
+	c.rules = []string{
+		"1.1.1.1 example.org #$managed by external-dns",
+	}
+
+	changes := &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			{
+				DNSName:    "app.example.com",
+				RecordType: endpoint.RecordTypeA,
+				Targets:    endpoint.Targets{"2.2.2.2"},
+			},
+			{
+				DNSName:    "app.private.example.com",
+				RecordType: endpoint.RecordTypeA,
+				Targets:    endpoint.Targets{"3.3.3.3"},
+			},
+		},
+		Delete: []*endpoint.Endpoint{
+			{
+				DNSName:    "example.org",
+				RecordType: endpoint.RecordTypeA,
+				Targets:    endpoint.Targets{"1.1.1.1"},
+			},
+		},
+	}
+
+	err := p.ApplyChanges(context.Background(), changes)
+	if err != nil {
+		t.Errorf("failed to apply changes: %v", err)
+	}
+
+	expectedRules := []string{
+		"1.1.1.1 example.org #$managed by external-dns",
+		"2.2.2.2 app.example.com #$managed by external-dns",
+		"@@||example.org #$managed by external-dns",
+		"@@||app.example.com #$managed by external-dns",
+	}
+	if !reflect.DeepEqual(c.rules, expectedRules) {
+		t.Errorf("rules do not match: got: %v, expected: %v", c.rules, expectedRules)
+	}
+}
+
+func TestNewDomainFilter(t *testing.T) {
+	df, err := newDomainFilter(DomainFilterOptions{Regex: `\.example\.com$`})
+	if err != nil {
+		t.Fatalf("newDomainFilter() error = %v", err)
+	}
+	if !df.Match("app.example.com") || df.Match("app.example.org") {
+		t.Errorf("regex domain filter does not match as expected")
+	}
+
+	_, err = newDomainFilter(DomainFilterOptions{Include: []string{"example.com"}, Regex: `\.example\.com$`})
+	if err == nil {
+		t.Errorf("expected error when combining domain lists and regex filters")
+	}
+
+	df, err = newDomainFilter(DomainFilterOptions{Include: []string{"example.com"}, Exclude: []string{"private.example.com", "internal.example.com"}})
+	if err != nil {
+		t.Fatalf("newDomainFilter() error = %v", err)
+	}
+	if !df.Match("app.example.com") || df.Match("app.internal.example.com") || df.Match("example.org") {
+		t.Errorf("domain filter does not match as expected")
+	}
+}
+
+func TestAdguardHomeProvider_Replicas(t *testing.T) {
+	primary := newMockClient()
+	upToDate := newMockClient()
+	// The replica missed a previous sync which created the record
+	upToDate.rules = append(upToDate.rules, "2.2.2.2 new.example.com #$managed by external-dns")
+	failing := &mockAdguardClient{err: errors.New("connection refused")}
+
+	p := &AdguardHomeProvider{
+		client:      primary,
+		primaryName: "primary",
+		replicas: []instance{
+			{name: "up-to-date", client: upToDate},
+			{name: "failing", client: failing},
+		},
+	}
+
+	err := p.ApplyChanges(context.Background(), &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			{DNSName: "new.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"2.2.2.2"}},
+		},
+	})
+	if err == nil || !strings.Contains(err.Error(), "instance failing") {
+		t.Fatalf("expected error of the failing instance, got %v", err)
+	}
+
+	expected := []string{
+		"# I am not for external-dns",
+		"1.1.1.1 example.com #$managed by external-dns",
+		"# myresponse notexample.com $managed by external-dns",
+		"2.2.2.2 new.example.com #$managed by external-dns",
+		"@@||example.com #$managed by external-dns",
+		"@@||new.example.com #$managed by external-dns",
+	}
+	for name, c := range map[string]*mockAdguardClient{"primary": primary, "up-to-date": upToDate} {
+		if !reflect.DeepEqual(c.rules, expected) {
+			t.Errorf("unexpected rules of %s instance: %v", name, c.rules)
+		}
+	}
+
+	failing.err = nil
+	failing.rules = []string{"3.3.3.3 other.example.com #$managed by external-dns"}
+
+	records, err := p.Records(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Errorf("expected records of the primary instance only, got %v", records)
+	}
+
+	p.recordsSource = recordsSourceUnion
+	records, err = p.Records(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 {
+		t.Errorf("expected deduplicated records of all instances, got %v", records)
+	}
+	for _, r := range records {
+		if r.DNSName == "new.example.com" && len(r.Targets) != 1 {
+			t.Errorf("expected duplicate targets to be merged, got %v", r.Targets)
+		}
+	}
+}
+
+// editingClient simulates a user adding a rule in the AdguardHome UI before every read listed in editOnRead.
+type editingClient struct {
+	*mockAdguardClient
+	reads      int
+	editOnRead map[int]bool
+}
+
+func (m *editingClient) GetFilteringRules(ctx context.Context) ([]string, error) {
+	m.reads++
+	if m.editOnRead[m.reads] {
+		m.rules = append(slices.Clone(m.rules), fmt.Sprintf("||ads-%d.example.com^", m.reads))
+	}
+	return m.mockAdguardClient.GetFilteringRules(ctx)
+}
+
+func TestAdguardHomeProvider_ConcurrentEdit(t *testing.T) {
+	changes := &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			{DNSName: "new.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"2.2.2.2"}},
+		},
+	}
+
+	c := &editingClient{mockAdguardClient: newMockClient(), editOnRead: map[int]bool{2: true}}
+	p := &AdguardHomeProvider{client: c, conflictRetries: 1}
+	conflicts := testutil.ToFloat64(ruleConflictsTotal)
+
+	if err := p.ApplyChanges(context.Background(), changes); err != nil {
+		t.Fatalf("ApplyChanges() error = %v", err)
+	}
+	if !slices.Contains(c.rules, "||ads-2.example.com^") || !slices.Contains(c.rules, "2.2.2.2 new.example.com #$managed by external-dns") {
+		t.Errorf("expected both the concurrent edit and the change to be saved, got %v", c.rules)
+	}
+	if got := testutil.ToFloat64(ruleConflictsTotal) - conflicts; got != 1 {
+		t.Errorf("expected 1 conflict, got %v", got)
+	}
+
+	c = &editingClient{mockAdguardClient: newMockClient(), editOnRead: map[int]bool{2: true, 3: true}}
+	p = &AdguardHomeProvider{client: c, conflictRetries: 1}
+	original := slices.Clone(c.rules)
+
+	if err := p.ApplyChanges(context.Background(), changes); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+	if slices.Contains(c.rules, "2.2.2.2 new.example.com #$managed by external-dns") || len(c.rules) != len(original)+2 {
+		t.Errorf("expected rules not to be saved on conflict, got %v", c.rules)
+	}
+}
+
+func TestAdguardHomeProvider_SkipNoopSave(t *testing.T) {
+	c := &mockAdguardClient{
+		rules: []string{
+			"# I am not for external-dns",
+			"1.1.1.1 example.com #$managed by external-dns",
+			"@@||example.com #$managed by external-dns",
+		},
+	}
+	p := &AdguardHomeProvider{client: c}
+	noops := testutil.ToFloat64(ruleSavesTotal.WithLabelValues("noop"))
+
+	// NS records are not supported, so nothing changes
+	err := p.ApplyChanges(context.Background(), &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			{DNSName: "example.com", RecordType: endpoint.RecordTypeNS, Targets: endpoint.Targets{"ns.example.com"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.saves != 0 {
+		t.Errorf("expected no save, got %d", c.saves)
+	}
+	if got := testutil.ToFloat64(ruleSavesTotal.WithLabelValues("noop")) - noops; got != 1 {
+		t.Errorf("expected 1 noop save, got %v", got)
+	}
+
+	err = p.ApplyChanges(context.Background(), &plan.Changes{
+		Delete: []*endpoint.Endpoint{
+			{DNSName: "example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"1.1.1.1"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.saves != 1 || !reflect.DeepEqual(c.rules, []string{"# I am not for external-dns"}) {
+		t.Errorf("expected the deletion to be saved, got %d saves of %v", c.saves, c.rules)
+	}
+}
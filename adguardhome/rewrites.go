@@ -0,0 +1,428 @@
+package adguardhome
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+// rewriteOwnershipPrefix starts comment rules recording DNS rewrites owned by the provider.
+// DNS rewrites have no comment field, so every owned rewrite gets a companion comment rule
+// in the custom filtering rules, similar to the TXT registry used by external-dns.
+const rewriteOwnershipPrefix = "! rewrite "
+
+const (
+	rewriteActionAdd    = "add"
+	rewriteActionDelete = "delete"
+	rewriteActionUpdate = "update"
+)
+
+// rewriteOp is a single change of a DNS rewrite.
+type rewriteOp struct {
+	action string
+	// target is the rewrite being deleted or updated
+	target RewriteEntry
+	// update is the rewrite being added or the new value of the updated rewrite
+	update RewriteEntry
+	labels endpoint.Labels
+}
+
+func (o rewriteOp) String() string {
+	switch o.action {
+	case rewriteActionAdd:
+		return fmt.Sprintf("add rewrite %s -> %s", o.update.Domain, o.update.Answer)
+	case rewriteActionDelete:
+		return fmt.Sprintf("delete rewrite %s -> %s", o.target.Domain, o.target.Answer)
+	default:
+		return fmt.Sprintf("update rewrite %s -> %s to %s -> %s", o.target.Domain, o.target.Answer, o.update.Domain, o.update.Answer)
+	}
+}
+
+// isRewriteRecord returns true for records stored as DNS rewrites by the rewrites backend,
+// other record types are stored as filtering rules.
+func isRewriteRecord(e *endpoint.Endpoint) bool {
+	return isAddressRecord(e) || e.RecordType == endpoint.RecordTypeCNAME
+}
+
+// rewriteRecordType returns the type of the record AdguardHome serves for the rewrite answer.
+func rewriteRecordType(answer string) string {
+	addr, err := netip.ParseAddr(answer)
+	if err != nil {
+		return endpoint.RecordTypeCNAME
+	}
+	if addr.Is6() && !addr.Is4In6() {
+		return endpoint.RecordTypeAAAA
+	}
+
+	return endpoint.RecordTypeA
+}
+
+func rewriteOwnershipToString(entry RewriteEntry, labels endpoint.Labels, o owner) string {
+	return o.mark(rewriteOwnershipPrefix+entry.Domain+" "+entry.Answer, labels)
+}
+
+func parseRewriteOwnership(rule string, o owner) (RewriteEntry, endpoint.Labels, error) {
+	entry, m, err := parseAnyRewriteOwnership(rule)
+	if err != nil {
+		return RewriteEntry{}, nil, err
+	}
+	if !o.owns(m) {
+		return RewriteEntry{}, nil, errNotManaged
+	}
+	return entry, m.labels, nil
+}
+
+// parseAnyRewriteOwnership parses the ownership rule of a rewrite regardless of its owner.
+func parseAnyRewriteOwnership(rule string) (RewriteEntry, marker, error) {
+	body, m, err := parseMarker(rule)
+	if err != nil || !strings.HasPrefix(body, rewriteOwnershipPrefix) {
+		return RewriteEntry{}, marker{}, errNotManaged
+	}
+
+	parts := strings.Fields(strings.TrimPrefix(body, rewriteOwnershipPrefix))
+	if len(parts) != 2 {
+		return RewriteEntry{}, marker{}, fmt.Errorf("invalid rule: %s", rule)
+	}
+
+	return RewriteEntry{Domain: parts[0], Answer: parts[1]}, m, nil
+}
+
+// ownedRewrites returns rewrites owned by the provider according to the ownership rules.
+func (p *AdguardHomeProvider) ownedRewrites(rules []string) (map[RewriteEntry]endpoint.Labels, error) {
+	owned := make(map[RewriteEntry]endpoint.Labels)
+	o := p.owner()
+	for _, rule := range rules {
+		entry, labels, err := parseRewriteOwnership(rule, o)
+		if err != nil {
+			if errors.Is(err, errNotManaged) {
+				continue
+			}
+			return nil, err
+		}
+		owned[entry] = labels
+	}
+
+	return owned, nil
+}
+
+// foreignRewrites returns rewrites owned by other owners according to their ownership rules.
+func (p *AdguardHomeProvider) foreignRewrites(rules []string) map[RewriteEntry]struct{} {
+	foreign := make(map[RewriteEntry]struct{})
+	o := p.owner()
+	for _, rule := range rules {
+		if entry, m, err := parseAnyRewriteOwnership(rule); err == nil && !o.owns(m) {
+			foreign[entry] = struct{}{}
+		}
+	}
+	return foreign
+}
+
+// rewriteRecords returns records of the rewrites backend: owned DNS rewrites and records stored as filtering rules.
+func (p *AdguardHomeProvider) rewriteRecords(ctx context.Context, c Client) ([]*endpoint.Endpoint, error) {
+	rules, err := c.GetFilteringRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rewrites, err := c.ListRewrites(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"func":     "records",
+		"rules":    rules,
+		"rewrites": rewrites,
+	}).Debugf("retrieved AdguardHome rules and rewrites")
+
+	owned, err := p.ownedRewrites(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	ret, err := p.ruleRecords(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rw := range rewrites {
+		labels, ok := owned[rw]
+		if !ok {
+			continue
+		}
+
+		recordType := rewriteRecordType(rw.Answer)
+		if !p.domainFilter.Match(rw.Domain) || !p.recordTypeEnabled(recordType) {
+			continue
+		}
+
+		ret = append(ret, &endpoint.Endpoint{
+			DNSName:    rw.Domain,
+			RecordType: recordType,
+			Targets:    endpoint.Targets{rw.Answer},
+			Labels:     labels,
+		})
+	}
+
+	return mergeEndpoints(ret), nil
+}
+
+// applyRewriteChanges applies A, AAAA and CNAME changes through the DNS rewrites API,
+// other records and the ownership of rewrites are stored as filtering rules.
+func (p *AdguardHomeProvider) applyRewriteChanges(ctx context.Context, inst instance, changes *plan.Changes) error {
+	c := inst.client
+	rules, err := c.GetFilteringRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	rewrites, err := c.ListRewrites(ctx)
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[RewriteEntry]struct{}, len(rewrites))
+	for _, rw := range rewrites {
+		existing[rw] = struct{}{}
+	}
+
+	owned, err := p.ownedRewrites(rules)
+	if err != nil {
+		return err
+	}
+	foreign := p.foreignRewrites(rules)
+
+	rewriteChanges := filterChanges(changes, isRewriteRecord)
+	ruleChanges := filterChanges(changes, func(e *endpoint.Endpoint) bool {
+		return !isRewriteRecord(e)
+	})
+
+	ops := planRewriteChanges(rewriteChanges, owned)
+	// Rewrites are changed before the rules are saved, so deletions are checked upfront
+	planned, err := p.rewriteBackendRules(rules, ruleChanges, simulateRewriteOps(owned, ops))
+	if err != nil {
+		return err
+	}
+	if err := p.checkDeletions(inst.name, rules, planned); err != nil {
+		return err
+	}
+
+	var opErr error
+	for _, op := range ops {
+		log.Debugf("%s", op)
+		opErr = p.applyRewriteOp(ctx, c, op, existing, owned, foreign)
+		if opErr != nil {
+			opErr = fmt.Errorf("failed to %s: %w", op, opErr)
+			break
+		}
+	}
+
+	// Ownership of rewrites changed before a failure still has to be persisted
+	err = p.saveRules(ctx, inst, rules, func(rules []string) ([]string, error) {
+		return p.rewriteBackendRules(rules, ruleChanges, owned)
+	}, p.observeSavedRecords)
+
+	return errors.Join(opErr, err)
+}
+
+// applyRewriteOp executes op and updates the existing and owned rewrites accordingly.
+// Rewrites owned by other owners are never adopted, changed or deleted.
+func (p *AdguardHomeProvider) applyRewriteOp(ctx context.Context, c Client, op rewriteOp, existing map[RewriteEntry]struct{}, owned map[RewriteEntry]endpoint.Labels, foreign map[RewriteEntry]struct{}) error {
+	isForeign := func(entry RewriteEntry) bool {
+		_, ok := foreign[entry]
+		return ok
+	}
+	if op.action != rewriteActionDelete && isForeign(op.update) {
+		if _, ok := owned[op.update]; !ok {
+			log.Warnf("skipping rewrite %s -> %s owned by another owner", op.update.Domain, op.update.Answer)
+			if op.action == rewriteActionAdd {
+				return nil
+			}
+			op = rewriteOp{action: rewriteActionDelete, target: op.target}
+		}
+	}
+
+	switch op.action {
+	case rewriteActionAdd:
+		// The rewrite could be left over from a sync which failed to persist ownership
+		if _, ok := existing[op.update]; !ok {
+			if err := c.AddRewrite(ctx, op.update); err != nil {
+				return err
+			}
+			existing[op.update] = struct{}{}
+		}
+		owned[op.update] = op.labels
+	case rewriteActionDelete:
+		// A rewrite shared with another owner is kept for it
+		if _, ok := existing[op.target]; ok && !isForeign(op.target) {
+			if err := c.DeleteRewrite(ctx, op.target); err != nil {
+				return err
+			}
+			delete(existing, op.target)
+		}
+		delete(owned, op.target)
+	case rewriteActionUpdate:
+		if op.target != op.update {
+			_, targetExists := existing[op.target]
+			_, updateExists := existing[op.update]
+			var err error
+			switch {
+			case targetExists && !isForeign(op.target):
+				err = c.UpdateRewrite(ctx, op.target, op.update)
+			// A rewrite shared with another owner is kept for it, the new value is added instead
+			case !updateExists:
+				err = c.AddRewrite(ctx, op.update)
+			}
+			if err != nil {
+				return err
+			}
+			if !isForeign(op.target) {
+				delete(existing, op.target)
+			}
+			delete(owned, op.target)
+			existing[op.update] = struct{}{}
+		}
+		owned[op.update] = op.labels
+	}
+
+	return nil
+}
+
+// rewriteBackendRules applies changes of records stored as filtering rules to rules and replaces ownership rules with owned.
+func (p *AdguardHomeProvider) rewriteBackendRules(rules []string, ruleChanges *plan.Changes, owned map[RewriteEntry]endpoint.Labels) ([]string, error) {
+	resultingRules, err := p.applyRuleChanges(rules, ruleChanges)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]RewriteEntry, 0, len(owned))
+	for entry := range owned {
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b RewriteEntry) int {
+		return strings.Compare(a.Domain+" "+a.Answer, b.Domain+" "+b.Answer)
+	})
+
+	o := p.owner()
+	resultingRules = slices.DeleteFunc(resultingRules, func(rule string) bool {
+		_, _, err := parseRewriteOwnership(rule, o)
+		return err == nil
+	})
+	for _, entry := range entries {
+		resultingRules = append(resultingRules, rewriteOwnershipToString(entry, owned[entry], o))
+	}
+	return resultingRules, nil
+}
+
+// planRewriteChanges converts changes to operations on DNS rewrites.
+// Changed targets of updated records are updated in place when possible, only owned rewrites are deleted or updated.
+func planRewriteChanges(changes *plan.Changes, owned map[RewriteEntry]endpoint.Labels) []rewriteOp {
+	var ops []rewriteOp
+
+	for _, e := range changes.Delete {
+		for _, target := range e.Targets {
+			entry := RewriteEntry{Domain: e.DNSName, Answer: target}
+			if _, ok := owned[entry]; !ok {
+				log.Warnf("skipping deletion of rewrite %s -> %s not owned by external-dns", entry.Domain, entry.Answer)
+				continue
+			}
+			ops = append(ops, rewriteOp{action: rewriteActionDelete, target: entry})
+		}
+	}
+
+	matched := make([]bool, len(changes.UpdateNew))
+	for i, oldEndpoint := range changes.UpdateOld {
+		var newEndpoint *endpoint.Endpoint
+		if j := matchingUpdate(changes, i); j != -1 && !matched[j] {
+			matched[j] = true
+			newEndpoint = changes.UpdateNew[j]
+		}
+		ops = append(ops, planRewriteUpdate(oldEndpoint, newEndpoint, owned)...)
+	}
+
+	for j, newEndpoint := range changes.UpdateNew {
+		if !matched[j] {
+			ops = append(ops, planRewriteUpdate(nil, newEndpoint, owned)...)
+		}
+	}
+
+	for _, e := range changes.Create {
+		for _, target := range e.Targets {
+			ops = append(ops, rewriteOp{
+				action: rewriteActionAdd,
+				update: RewriteEntry{Domain: e.DNSName, Answer: target},
+				labels: e.Labels,
+			})
+		}
+	}
+
+	return ops
+}
+
+// matchingUpdate returns the index of the new state of the i-th old endpoint of an update, -1 when there is none.
+// external-dns keeps both lists in the same order, other positions are searched as a fallback.
+func matchingUpdate(changes *plan.Changes, i int) int {
+	old := changes.UpdateOld[i]
+	same := func(e *endpoint.Endpoint) bool {
+		return e.DNSName == old.DNSName && e.RecordType == old.RecordType
+	}
+	if i < len(changes.UpdateNew) && same(changes.UpdateNew[i]) {
+		return i
+	}
+	return slices.IndexFunc(changes.UpdateNew, same)
+}
+
+// planRewriteUpdate returns operations changing targets of oldEndpoint to targets of newEndpoint, either can be nil.
+// Targets are compared as sets: targets kept by the update only get their labels updated, removed targets are
+// updated in place to added ones and the rest is deleted or added.
+func planRewriteUpdate(oldEndpoint, newEndpoint *endpoint.Endpoint, owned map[RewriteEntry]endpoint.Labels) []rewriteOp {
+	var oldTargets, newTargets endpoint.Targets
+	var labels endpoint.Labels
+	if oldEndpoint != nil {
+		oldTargets = oldEndpoint.Targets
+	}
+	if newEndpoint != nil {
+		newTargets, labels = newEndpoint.Targets, newEndpoint.Labels
+	}
+
+	var ops []rewriteOp
+	var added []RewriteEntry
+	for _, target := range newTargets {
+		entry := RewriteEntry{Domain: newEndpoint.DNSName, Answer: target}
+		if slices.Contains(oldTargets, target) {
+			// Adding an owned rewrite only updates its labels
+			ops = append(ops, rewriteOp{action: rewriteActionAdd, update: entry, labels: labels})
+			continue
+		}
+		added = append(added, entry)
+	}
+
+	for _, target := range oldTargets {
+		if slices.Contains(newTargets, target) {
+			continue
+		}
+		entry := RewriteEntry{Domain: oldEndpoint.DNSName, Answer: target}
+		if _, ok := owned[entry]; !ok {
+			log.Warnf("skipping deletion of rewrite %s -> %s not owned by external-dns", entry.Domain, entry.Answer)
+			continue
+		}
+		if len(added) == 0 {
+			ops = append(ops, rewriteOp{action: rewriteActionDelete, target: entry})
+			continue
+		}
+		ops = append(ops, rewriteOp{action: rewriteActionUpdate, target: entry, update: added[0], labels: labels})
+		added = added[1:]
+	}
+
+	for _, entry := range added {
+		ops = append(ops, rewriteOp{action: rewriteActionAdd, update: entry, labels: labels})
+	}
+	return ops
+}
@@ -0,0 +1,254 @@
+package adguardhome
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func TestAdguardHomeProvider_RewritesBackend(t *testing.T) {
+	c := newMockClient()
+	c.rewrites = []RewriteEntry{
+		{Domain: "manual.example.com", Answer: "10.0.0.1"},
+	}
+	p := &AdguardHomeProvider{
+		client:  c,
+		backend: backendRewrites,
+	}
+
+	changes := &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			{
+				DNSName:    "app.example.com",
+				RecordType: endpoint.RecordTypeA,
+				Targets:    endpoint.Targets{"1.1.1.1", "2.2.2.2"},
+				Labels:     endpoint.Labels{"owner": "default"},
+			},
+			{
+				DNSName:    "alias.example.com",
+				RecordType: endpoint.RecordTypeCNAME,
+				Targets:    endpoint.Targets{"app.example.com"},
+			},
+			{
+				DNSName:    "a-app.example.com",
+				RecordType: endpoint.RecordTypeTXT,
+				Targets:    endpoint.Targets{"heritage=external-dns"},
+			},
+		},
+	}
+
+	err := p.ApplyChanges(context.Background(), changes)
+	if err != nil {
+		t.Fatalf("failed to apply changes: %v", err)
+	}
+
+	expectedRewrites := []RewriteEntry{
+		{Domain: "manual.example.com", Answer: "10.0.0.1"},
+		{Domain: "app.example.com", Answer: "1.1.1.1"},
+		{Domain: "app.example.com", Answer: "2.2.2.2"},
+		{Domain: "alias.example.com", Answer: "app.example.com"},
+	}
+	if !reflect.DeepEqual(c.rewrites, expectedRewrites) {
+		t.Errorf("rewrites do not match: got: %v, expected: %v", c.rewrites, expectedRewrites)
+	}
+
+	expectedRules := []string{
+		"# I am not for external-dns",
+		"1.1.1.1 example.com #$managed by external-dns",
+		"# myresponse notexample.com $managed by external-dns",
+		"# heritage=external-dns a-app.example.com $managed by external-dns",
+		"@@||example.com #$managed by external-dns",
+		"! rewrite alias.example.com app.example.com #$managed by external-dns",
+		`! rewrite app.example.com 1.1.1.1 #$managed by external-dns;labels={"owner":"default"}`,
+		`! rewrite app.example.com 2.2.2.2 #$managed by external-dns;labels={"owner":"default"}`,
+	}
+	if !reflect.DeepEqual(c.rules, expectedRules) {
+		t.Errorf("rules do not match: got: %v, expected: %v", c.rules, expectedRules)
+	}
+
+	// Drop records stored as rules by the rules backend to check rewrites only
+	c.rules = append([]string{"# I am not for external-dns"}, expectedRules[3:]...)
+
+	got, err := p.Records(context.Background())
+	if err != nil {
+		t.Fatalf("failed to fetch records: %v", err)
+	}
+
+	expected := []*endpoint.Endpoint{
+		{
+			DNSName:    "a-app.example.com",
+			RecordType: endpoint.RecordTypeTXT,
+			Targets:    endpoint.Targets{"heritage=external-dns"},
+		},
+		{
+			DNSName:    "app.example.com",
+			RecordType: endpoint.RecordTypeA,
+			Targets:    endpoint.Targets{"1.1.1.1", "2.2.2.2"},
+			Labels:     endpoint.Labels{"owner": "default"},
+		},
+		{
+			DNSName:    "alias.example.com",
+			RecordType: endpoint.RecordTypeCNAME,
+			Targets:    endpoint.Targets{"app.example.com"},
+		},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("records do not match: got: %v, expected: %v", got, expected)
+	}
+
+	changes = &plan.Changes{
+		UpdateOld: []*endpoint.Endpoint{
+			{
+				DNSName:    "app.example.com",
+				RecordType: endpoint.RecordTypeA,
+				Targets:    endpoint.Targets{"1.1.1.1", "2.2.2.2"},
+			},
+		},
+		UpdateNew: []*endpoint.Endpoint{
+			{
+				DNSName:    "app.example.com",
+				RecordType: endpoint.RecordTypeA,
+				Targets:    endpoint.Targets{"3.3.3.3"},
+			},
+		},
+		Delete: []*endpoint.Endpoint{
+			{
+				DNSName:    "alias.example.com",
+				RecordType: endpoint.RecordTypeCNAME,
+				Targets:    endpoint.Targets{"app.example.com"},
+			},
+			{
+				DNSName:    "manual.example.com",
+				RecordType: endpoint.RecordTypeA,
+				Targets:    endpoint.Targets{"10.0.0.1"},
+			},
+		},
+	}
+
+	err = p.ApplyChanges(context.Background(), changes)
+	if err != nil {
+		t.Fatalf("failed to apply changes: %v", err)
+	}
+
+	// Rewrites not owned by the provider are never touched
+	expectedRewrites = []RewriteEntry{
+		{Domain: "manual.example.com", Answer: "10.0.0.1"},
+		{Domain: "app.example.com", Answer: "3.3.3.3"},
+	}
+	if !reflect.DeepEqual(c.rewrites, expectedRewrites) {
+		t.Errorf("rewrites do not match: got: %v, expected: %v", c.rewrites, expectedRewrites)
+	}
+
+	expectedRules = []string{
+		"# I am not for external-dns",
+		"# heritage=external-dns a-app.example.com $managed by external-dns",
+		"! rewrite app.example.com 3.3.3.3 #$managed by external-dns",
+	}
+	if !reflect.DeepEqual(c.rules, expectedRules) {
+		t.Errorf("rules do not match: got: %v, expected: %v", c.rules, expectedRules)
+	}
+}
+
+func TestAdguardHomeProvider_RewritesOverlappingUpdate(t *testing.T) {
+	c := &mockAdguardClient{
+		rules: []string{
+			"! rewrite app.example.com 1.1.1.1 #$managed by external-dns",
+			"! rewrite app.example.com 2.2.2.2 #$managed by external-dns",
+		},
+		rewrites: []RewriteEntry{
+			{Domain: "app.example.com", Answer: "1.1.1.1"},
+			{Domain: "app.example.com", Answer: "2.2.2.2"},
+		},
+	}
+	p := &AdguardHomeProvider{client: c, backend: backendRewrites}
+
+	err := p.ApplyChanges(context.Background(), &plan.Changes{
+		UpdateOld: []*endpoint.Endpoint{
+			{DNSName: "app.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"1.1.1.1", "2.2.2.2"}},
+		},
+		UpdateNew: []*endpoint.Endpoint{
+			{DNSName: "app.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"2.2.2.2", "3.3.3.3"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to apply changes: %v", err)
+	}
+
+	// The kept target is left as-is, only the removed one is updated to the added one
+	expectedRewrites := []RewriteEntry{
+		{Domain: "app.example.com", Answer: "3.3.3.3"},
+		{Domain: "app.example.com", Answer: "2.2.2.2"},
+	}
+	if !reflect.DeepEqual(c.rewrites, expectedRewrites) {
+		t.Errorf("rewrites do not match: got: %v, expected: %v", c.rewrites, expectedRewrites)
+	}
+
+	got, err := p.Records(context.Background())
+	if err != nil {
+		t.Fatalf("failed to fetch records: %v", err)
+	}
+	expected := []*endpoint.Endpoint{
+		{DNSName: "app.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"3.3.3.3", "2.2.2.2"}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("records do not match: got: %v, expected: %v", got, expected)
+	}
+}
+
+func TestRewriteRecordType(t *testing.T) {
+	tests := map[string]string{
+		"1.1.1.1":         endpoint.RecordTypeA,
+		"2001:db8::1":     endpoint.RecordTypeAAAA,
+		"lb.example.net":  endpoint.RecordTypeCNAME,
+		"::ffff:10.0.0.1": endpoint.RecordTypeA,
+	}
+	for answer, want := range tests {
+		if got := rewriteRecordType(answer); got != want {
+			t.Errorf("rewriteRecordType(%q) = %s, want %s", answer, got, want)
+		}
+	}
+}
+
+func TestAdguardHomeProvider_RewritesOwners(t *testing.T) {
+	c := &mockAdguardClient{}
+	a := &AdguardHomeProvider{client: c, backend: backendRewrites, managedBySuffix: "a"}
+	b := &AdguardHomeProvider{client: c, backend: backendRewrites, managedBySuffix: "b"}
+	record := &endpoint.Endpoint{DNSName: "app.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"1.1.1.1"}}
+	rewrite := RewriteEntry{Domain: "app.example.com", Answer: "1.1.1.1"}
+
+	// The rewrite of a is not adopted by b
+	for _, p := range []*AdguardHomeProvider{a, b} {
+		if err := p.ApplyChanges(context.Background(), &plan.Changes{Create: []*endpoint.Endpoint{record}}); err != nil {
+			t.Fatalf("failed to apply changes: %v", err)
+		}
+	}
+	expectedRules := []string{"! rewrite app.example.com 1.1.1.1 #$managed by external-dns;ref:a"}
+	if !reflect.DeepEqual(c.rules, expectedRules) {
+		t.Errorf("rules do not match: got: %v, expected: %v", c.rules, expectedRules)
+	}
+	if got, err := b.Records(context.Background()); err != nil || len(got) != 0 {
+		t.Errorf("expected no records of b, got %v, %v", got, err)
+	}
+
+	// Rewrites shared by both owners, e.g. adopted by previous versions, are kept for the other owner
+	c.rules = append(c.rules, "! rewrite app.example.com 1.1.1.1 #$managed by external-dns;ref:b")
+	if err := a.ApplyChanges(context.Background(), &plan.Changes{Delete: []*endpoint.Endpoint{record}}); err != nil {
+		t.Fatalf("failed to apply changes: %v", err)
+	}
+	if expected := []RewriteEntry{rewrite}; !reflect.DeepEqual(c.rewrites, expected) {
+		t.Errorf("rewrites do not match: got: %v, expected: %v", c.rewrites, expected)
+	}
+	if got, err := b.Records(context.Background()); err != nil || len(got) != 1 {
+		t.Errorf("expected the record of b to be kept, got %v, %v", got, err)
+	}
+
+	if err := b.ApplyChanges(context.Background(), &plan.Changes{Delete: []*endpoint.Endpoint{record}}); err != nil {
+		t.Fatalf("failed to apply changes: %v", err)
+	}
+	if len(c.rewrites) != 0 || len(c.rules) != 0 {
+		t.Errorf("expected no rewrites and rules, got %v and %v", c.rewrites, c.rules)
+	}
+}
@@ -0,0 +1,80 @@
+package adguardhome
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// startupModeFail fails creating the provider when AdguardHome is unreachable
+	startupModeFail = "fail"
+	// startupModeBackground creates the provider and connects to AdguardHome in the background
+	startupModeBackground = "background"
+)
+
+var errNotConnected = errors.New("AdguardHome is not reachable yet")
+
+// startupBackoff is used to retry connecting to AdguardHome in the background startup mode
+var startupBackoff = backoff{initial: time.Second, max: 30 * time.Second, factor: 2}
+
+// statusChecker is implemented by clients able to verify the connection to AdguardHome
+type statusChecker interface {
+	status(ctx context.Context) error
+}
+
+// connection holds the error preventing the provider from using AdguardHome, nil once connected.
+type connection struct {
+	mu  sync.RWMutex
+	err error
+}
+
+func (c *connection) get() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.err
+}
+
+func (c *connection) set(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+}
+
+// connect checks that every AdguardHome instance is reachable and accepts the credentials.
+func (p *AdguardHomeProvider) connect(ctx context.Context) error {
+	var errs []error
+	for _, inst := range p.instances() {
+		sc, ok := inst.client.(statusChecker)
+		if !ok {
+			continue
+		}
+		if err := sc.status(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("instance %s: %w", inst.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// connectInBackground retries connecting to AdguardHome until it succeeds or ctx is done,
+// Records and ApplyChanges fail until then.
+func (p *AdguardHomeProvider) connectInBackground(ctx context.Context) {
+	p.conn.set(errNotConnected)
+
+	go func() {
+		err := startupBackoff.retry(ctx, p.connect, func(err error, attempt int, delay time.Duration) {
+			p.conn.set(fmt.Errorf("%w: %w", errNotConnected, err))
+			log.WithError(err).WithField("attempt", attempt).Warnf("AdguardHome is unreachable, retrying in %s", delay)
+		})
+		if err != nil {
+			return
+		}
+
+		p.conn.set(nil)
+		log.Info("connected to AdguardHome")
+	}()
+}
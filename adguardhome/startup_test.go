@@ -0,0 +1,64 @@
+package adguardhome
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewAdguardHomeProvider_StartupMode(t *testing.T) {
+	var available atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/control/filtering/status" {
+			_, _ = w.Write([]byte(`{"enabled": true, "user_rules": []}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := DefaultConfig()
+	cfg.URL = srv.URL
+	cfg.User = "admin"
+	cfg.Password = "secret"
+	cfg.Retry.MaxAttempts = 1
+
+	if _, err := NewAdguardHomeProvider(cfg); err == nil {
+		t.Fatal("expected error of the unreachable AdguardHome in the fail startup mode")
+	}
+
+	defer func(b backoff) { startupBackoff = b }(startupBackoff)
+	startupBackoff = backoff{initial: 10 * time.Millisecond, max: 10 * time.Millisecond, factor: 1}
+
+	cfg.StartupMode = startupModeBackground
+	p, err := NewAdguardHomeProvider(cfg)
+	if err != nil {
+		t.Fatalf("NewAdguardHomeProvider() error = %v", err)
+	}
+
+	if _, err := p.Records(context.Background()); !errors.Is(err, errNotConnected) {
+		t.Errorf("expected Records to fail until AdguardHome is reachable, got %v", err)
+	}
+	if err := p.ApplyChanges(context.Background(), nil); !errors.Is(err, errNotConnected) {
+		t.Errorf("expected ApplyChanges to fail until AdguardHome is reachable, got %v", err)
+	}
+
+	available.Store(true)
+	deadline := time.Now().Add(5 * time.Second)
+	for p.conn.get() != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("provider didn't connect: %v", p.conn.get())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := p.Records(context.Background()); err != nil {
+		t.Errorf("Records() error = %v", err)
+	}
+}
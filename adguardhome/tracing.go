@@ -0,0 +1,35 @@
+package adguardhome
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+// tracer creates spans of the provider, spans are dropped unless a tracer provider is configured by the binary
+var tracer = otel.Tracer("github.com/zekker6/external-dns-adguard-provider/adguardhome")
+
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan marks the span as failed when err is set and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func changesAttributes(changes *plan.Changes) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int("changes.create", len(changes.Create)),
+		attribute.Int("changes.update", len(changes.UpdateNew)),
+		attribute.Int("changes.delete", len(changes.Delete)),
+	}
+}
@@ -0,0 +1,54 @@
+package adguardhome
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func TestTracing_ApplyChanges(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	p := &AdguardHomeProvider{
+		client: newMockClient(),
+	}
+
+	err := p.ApplyChanges(context.Background(), &plan.Changes{
+		Create: []*endpoint.Endpoint{
+			{DNSName: "new.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"2.2.2.2"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := recorder.Ended()
+	names := make(map[string]sdktrace.ReadOnlySpan, len(spans))
+	for _, s := range spans {
+		names[s.Name()] = s
+	}
+	for _, name := range []string{"ApplyChanges", "ApplyChanges instance", "applyRuleChanges"} {
+		if _, ok := names[name]; !ok {
+			t.Errorf("expected span %q, got %v", name, spans)
+		}
+	}
+
+	root := names["ApplyChanges"]
+	if root == nil {
+		return
+	}
+	if !slices.Contains(root.Attributes(), attribute.Int("changes.create", 1)) {
+		t.Errorf("expected create count attribute, got %v", root.Attributes())
+	}
+	if instance := names["ApplyChanges instance"]; instance != nil && instance.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Errorf("expected instance span to be a child of ApplyChanges")
+	}
+}
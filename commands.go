@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/zekker6/external-dns-adguard-provider/adguardhome"
+)
+
+// command is a subcommand of the binary, the webhook server is started when no command is given.
+type command struct {
+	description string
+	run         func(args []string) error
+}
+
+var commands = map[string]command{
+	"restore": {description: "Restore filtering rules of an AdguardHome instance from a backup", run: runRestore},
+	"export":  {description: "Write managed records to a YAML, JSON or zone file", run: runExport},
+	"import":  {description: "Create records from a YAML or JSON file written by export", run: runImport},
+	"lint":    {description: "Check managed filtering rules and fix malformed ones", run: runLint},
+	"migrate": {description: "Move managed rules to another owner reference or marker version", run: runMigrate},
+}
+
+// runCommand runs the subcommand named by the first argument and reports whether one was found.
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return false
+	}
+
+	if err := cmd.run(args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", args[0], err)
+		os.Exit(1)
+	}
+	return true
+}
+
+// printCommands lists subcommands in the usage message
+func printCommands() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(flag.CommandLine.Output(), "\nCommands:")
+	for _, name := range names {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %-10s %s\n", name, commands[name].description)
+	}
+}
+
+// loadCommandConfig loads the configuration of a subcommand from the file and environment variables.
+// Subcommands never start in the background, they need AdguardHome right away.
+func loadCommandConfig(path string) (*adguardhome.Config, error) {
+	cfg, err := adguardhome.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg.StartupMode = "fail"
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zekker6/external-dns-adguard-provider/adguardhome"
+)
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to a YAML or JSON configuration file")
+	format := fs.String("format", "", "Output format: yaml, json or zone, guessed from the output file extension by default")
+	output := fs.String("output", "", "Path of the output file, records are written to stdout by default")
+	_ = fs.Parse(args)
+
+	if *format == "" {
+		*format = formatFromPath(*output)
+	}
+
+	cfg, err := loadCommandConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	p, err := adguardhome.NewAdguardHomeProvider(cfg)
+	if err != nil {
+		return err
+	}
+
+	endpoints, err := p.Records(context.Background())
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := adguardhome.WriteRecords(w, endpoints, *format); err != nil {
+		return err
+	}
+
+	if *output != "" {
+		fmt.Printf("Exported %d records to %s\n", len(endpoints), *output)
+	}
+	return nil
+}
+
+// formatFromPath returns the export format matching the file extension, YAML when unknown
+func formatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return adguardhome.FormatJSON
+	case ".zone", ".db":
+		return adguardhome.FormatZone
+	}
+	return adguardhome.FormatYAML
+}
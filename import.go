@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/zekker6/external-dns-adguard-provider/adguardhome"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to a YAML or JSON configuration file")
+	file := fs.String("file", "", "Path of a YAML or JSON file written by the export command")
+	dryRun := fs.Bool("dry-run", false, "Log the changes of filtering rules instead of applying them")
+	_ = fs.Parse(args)
+
+	if *file == "" {
+		return errors.New("file is required")
+	}
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	endpoints, err := adguardhome.ReadRecords(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", *file, err)
+	}
+
+	cfg, err := loadCommandConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		cfg.DryRun = true
+	}
+	p, err := adguardhome.NewAdguardHomeProvider(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Records are created the same way as by external-dns, existing records are kept
+	if err := p.ApplyChanges(context.Background(), &plan.Changes{Create: endpoints}); err != nil {
+		return err
+	}
+
+	if cfg.DryRun {
+		fmt.Println("Dry run, no records were imported")
+		return nil
+	}
+	fmt.Printf("Imported %d records from %s\n", len(endpoints), *file)
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/zekker6/external-dns-adguard-provider/adguardhome"
+)
+
+func runLint(args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to a YAML or JSON configuration file")
+	fix := fs.String("fix", "", "Fix problems found: repair removes malformed and orphan rules, quarantine comments malformed rules out and removes orphan rules")
+	verbose := fs.Bool("verbose", false, "Print the class of every rule instead of problems only")
+	_ = fs.Parse(args)
+
+	cfg, err := loadCommandConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	p, err := adguardhome.NewAdguardHomeProvider(cfg)
+	if err != nil {
+		return err
+	}
+
+	reports, err := p.LintRules(context.Background(), *fix)
+	problems := 0
+	for _, report := range reports {
+		problems += report.Problems()
+		printLintReport(report, *verbose)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case problems == 0:
+		fmt.Println("No problems found")
+	case *fix == "":
+		return fmt.Errorf("found %d problems, fix them with -fix repair or -fix quarantine", problems)
+	case cfg.DryRun:
+		fmt.Printf("Dry run, %d problems were not fixed\n", problems)
+	default:
+		fmt.Printf("Fixed %d problems\n", problems)
+	}
+	return nil
+}
+
+func printLintReport(report adguardhome.LintReport, verbose bool) {
+	counts := make(map[string]int)
+	for _, lint := range report.Rules {
+		counts[lint.Class]++
+	}
+	fmt.Printf("Instance %s: %d unmanaged, %d managed, %d malformed, %d orphan, %d quarantined rules\n", report.Instance,
+		counts[adguardhome.RuleUnmanaged], counts[adguardhome.RuleManaged], counts[adguardhome.RuleMalformed],
+		counts[adguardhome.RuleOrphan], counts[adguardhome.RuleQuarantined])
+
+	for _, lint := range report.Rules {
+		switch {
+		case lint.Err != nil:
+			// Parse errors include the rule
+			fmt.Printf("  %-11s %v\n", lint.Class, lint.Err)
+		case verbose || lint.Class == adguardhome.RuleOrphan:
+			fmt.Printf("  %-11s %s\n", lint.Class, lint.Rule)
+		}
+	}
+}
@@ -1,23 +1,57 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
-	"time"
+	"os/signal"
+	"syscall"
 
 	log "github.com/sirupsen/logrus"
-	"sigs.k8s.io/external-dns/provider/webhook/api"
 
 	"github.com/zekker6/external-dns-adguard-provider/adguardhome"
 )
 
 var (
-	dryRun   = flag.Bool("dry-run", false, "Do not apply changes, just print them")
-	logLevel = flag.String("log-level", "info", "Log level (debug, info, error)")
+	configPath = flag.String("config", "", "Path to a YAML or JSON configuration file")
+	dryRun     = flag.Bool("dry-run", false, "Read AdguardHome and log changes instead of applying them")
+	dryRunDiff = flag.String("dry-run-diff", "", "Rules included in dry run diffs: managed or full, overrides ADGUARD_HOME_DRY_RUN_DIFF (default managed)")
+	logLevel   = flag.String("log-level", "info", "Log level (debug, info, error)")
+
+	backend      = flag.String("backend", "", "Storage of records in AdguardHome: rules or rewrites, overrides ADGUARD_HOME_BACKEND")
+	managedByRef = flag.String("managed-by-ref", "", "Owner reference of managed rules, overrides ADGUARD_HOME_MANAGED_BY_REF")
+	recordTypes  = flag.String("record-types", "", "Comma separated list of record types to manage, overrides ADGUARD_HOME_RECORD_TYPES")
+
+	domainFilter         = flag.String("domain-filter", "", "Comma separated list of domains to manage, overrides ADGUARD_HOME_DOMAIN_FILTER")
+	excludeDomains       = flag.String("exclude-domains", "", "Comma separated list of domains to exclude, overrides ADGUARD_HOME_EXCLUDE_DOMAINS")
+	regexDomainFilter    = flag.String("regex-domain-filter", "", "Regular expression of domains to manage, overrides ADGUARD_HOME_REGEX_DOMAIN_FILTER")
+	regexDomainExclusion = flag.String("regex-domain-exclusion", "", "Regular expression of domains to exclude, overrides ADGUARD_HOME_REGEX_DOMAIN_EXCLUSION")
+
+	listenAddress = flag.String("listen-address", "", "Address of the webhook server, overrides ADGUARD_HOME_LISTEN_ADDRESS (default :8888)")
+	readTimeout   = flag.Duration("read-timeout", 0, "Read timeout of the webhook server, overrides ADGUARD_HOME_READ_TIMEOUT (default 10s)")
+	writeTimeout  = flag.Duration("write-timeout", 0, "Write timeout of the webhook server, overrides ADGUARD_HOME_WRITE_TIMEOUT (default 10s)")
+
+	startupMode         = flag.String("startup-mode", "", "fail to exit when AdguardHome is unreachable on startup or background to keep retrying, overrides ADGUARD_HOME_STARTUP_MODE (default fail)")
+	allowMassDeletion   = flag.Bool("allow-mass-deletion", false, "Apply changes deleting more managed records than allowed by the deletion guard, overrides ADGUARD_HOME_ALLOW_MASS_DELETION")
+	healthCheckInterval = flag.Duration("health-check-interval", 0, "Interval of AdguardHome health checks, overrides ADGUARD_HOME_HEALTH_CHECK_INTERVAL (default 30s)")
+
+	tlsCertFile     = flag.String("tls-cert-file", "", "Certificate of the webhook server, enables TLS, overrides ADGUARD_HOME_TLS_CERT_FILE")
+	tlsKeyFile      = flag.String("tls-key-file", "", "Private key of the webhook server certificate, overrides ADGUARD_HOME_TLS_KEY_FILE")
+	tlsClientCAFile = flag.String("tls-client-ca-file", "", "CA bundle used to verify client certificates, enables mTLS, overrides ADGUARD_HOME_TLS_CLIENT_CA_FILE")
 )
 
 func main() {
+	if runCommand(os.Args[1:]) {
+		return
+	}
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] | <command> [flags]\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+		printCommands()
+	}
 	flag.Parse()
 
 	log.SetFormatter(&log.JSONFormatter{})
@@ -34,16 +68,115 @@ func main() {
 		os.Exit(1)
 	}
 
-	p, err := adguardhome.NewAdguardHomeProvider(*dryRun)
+	cfg, err := adguardhome.LoadConfig(*configPath)
+	if err != nil {
+		exitWithConfigErrors(err)
+	}
+	applyFlags(cfg)
+	if err := cfg.Validate(); err != nil {
+		exitWithConfigErrors(err)
+	}
+
+	// SIGTERM is sent by Kubernetes before the pod is killed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := setupTracing(ctx)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to set up tracing")
+	}
+
+	p, err := adguardhome.NewAdguardHomeProvider(cfg)
 	if err != nil {
 		log.WithError(err).Fatal("Failed to create AdguardHomeProvider")
 		os.Exit(1)
 	}
 
-	st := make(chan struct{})
-	go func() {
-		<-st
-		log.Info("AdguardHomeProvider started on :8888")
-	}()
-	api.StartHTTPApi(p, st, 10*time.Second, 10*time.Second, ":8888")
+	p.StartHealthChecks(ctx, cfg.HealthCheckInterval.Duration)
+
+	srv, err := newServer(p, cfg.Server)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to create the webhook server")
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- serve(srv) }()
+
+	select {
+	case err := <-served:
+		_ = shutdownTracing(context.Background())
+		log.WithError(err).Fatal("Webhook server failed")
+	case <-ctx.Done():
+	}
+	stop()
+
+	// In-flight webhook calls are bounded by the write timeout of the server
+	log.Info("Shutting down the webhook server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.WriteTimeout.Duration)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.WithError(err).Error("Failed to shut down the webhook server")
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.WithError(err).Error("Failed to flush pending spans")
+	}
+}
+
+// applyFlags overrides configuration values with flags set on the command line
+func applyFlags(cfg *adguardhome.Config) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "dry-run":
+			cfg.DryRun = *dryRun
+		case "dry-run-diff":
+			cfg.DryRunDiff = *dryRunDiff
+		case "backend":
+			cfg.Backend = *backend
+		case "managed-by-ref":
+			cfg.ManagedByRef = *managedByRef
+		case "record-types":
+			cfg.RecordTypes = adguardhome.SplitList(*recordTypes)
+		case "domain-filter":
+			cfg.DomainFilter.Include = adguardhome.SplitList(*domainFilter)
+		case "exclude-domains":
+			cfg.DomainFilter.Exclude = adguardhome.SplitList(*excludeDomains)
+		case "regex-domain-filter":
+			cfg.DomainFilter.Regex = *regexDomainFilter
+		case "regex-domain-exclusion":
+			cfg.DomainFilter.RegexExclusion = *regexDomainExclusion
+		case "listen-address":
+			cfg.Server.ListenAddress = *listenAddress
+		case "read-timeout":
+			cfg.Server.ReadTimeout.Duration = *readTimeout
+		case "write-timeout":
+			cfg.Server.WriteTimeout.Duration = *writeTimeout
+		case "startup-mode":
+			cfg.StartupMode = *startupMode
+		case "health-check-interval":
+			cfg.HealthCheckInterval.Duration = *healthCheckInterval
+		case "allow-mass-deletion":
+			cfg.DeletionGuard.AllowMassDeletion = *allowMassDeletion
+		case "tls-cert-file":
+			cfg.Server.TLS.CertFile = *tlsCertFile
+		case "tls-key-file":
+			cfg.Server.TLS.KeyFile = *tlsKeyFile
+		case "tls-client-ca-file":
+			cfg.Server.TLS.ClientCAFile = *tlsClientCAFile
+		}
+	})
+}
+
+// exitWithConfigErrors prints every configuration problem on a separate line and exits
+func exitWithConfigErrors(err error) {
+	errs := []error{err}
+	var joined interface{ Unwrap() []error }
+	if errors.As(err, &joined) {
+		errs = joined.Unwrap()
+	}
+
+	fmt.Fprintln(os.Stderr, "Invalid configuration:")
+	for _, e := range errs {
+		fmt.Fprintf(os.Stderr, "  - %s\n", e)
+	}
+	os.Exit(1)
 }
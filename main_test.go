@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/zekker6/external-dns-adguard-provider/adguardhome"
+)
+
+func TestApplyFlags(t *testing.T) {
+	t.Setenv("ADGUARD_HOME_BACKEND", "rules")
+	t.Setenv("ADGUARD_HOME_MANAGED_BY_REF", "env")
+	t.Setenv("ADGUARD_HOME_WRITE_TIMEOUT", "20s")
+
+	set := func(name, value string) {
+		f := flag.Lookup(name)
+		previous := f.Value.String()
+		if err := flag.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = f.Value.Set(previous) })
+	}
+	set("backend", "rewrites")
+	set("write-timeout", "30s")
+
+	cfg, err := adguardhome.LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	applyFlags(cfg)
+
+	if cfg.Backend != "rewrites" {
+		t.Errorf("expected flag to override the environment, got backend %q", cfg.Backend)
+	}
+	if cfg.Server.WriteTimeout.String() != "30s" {
+		t.Errorf("expected flag to override the environment, got write timeout %s", cfg.Server.WriteTimeout)
+	}
+	if cfg.ManagedByRef != "env" {
+		t.Errorf("expected environment to be kept without a flag, got managed by ref %q", cfg.ManagedByRef)
+	}
+	if cfg.Server.ListenAddress != ":8888" {
+		t.Errorf("expected default to be kept without a flag, got listen address %q", cfg.Server.ListenAddress)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/zekker6/external-dns-adguard-provider/adguardhome"
+)
+
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to a YAML or JSON configuration file")
+	from := fs.String("from", "", "Owner reference of the rules to migrate, the default owner when empty")
+	to := fs.String("to", "", "Owner reference the rules are migrated to, the -from reference by default")
+	markerVersion := fs.Int("marker-version", 0, "Version of the ownership marker written to migrated rules, the configured version by default")
+	dryRun := fs.Bool("dry-run", false, "Print the changes without saving them")
+	_ = fs.Parse(args)
+
+	toSet := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "to" {
+			toSet = true
+		}
+	})
+	if !toSet {
+		*to = *from
+	}
+
+	cfg, err := loadCommandConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if *markerVersion == 0 {
+		*markerVersion = cfg.MarkerVersion
+	}
+
+	p, err := adguardhome.NewAdguardHomeProvider(cfg)
+	if err != nil {
+		return err
+	}
+
+	diff, err := p.MigrateRules(context.Background(), *from, *to, *markerVersion, *dryRun || cfg.DryRun)
+	fmt.Print(diff)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case diff == "":
+		fmt.Println("Nothing to migrate")
+	case *dryRun || cfg.DryRun:
+		fmt.Println("Dry run, no rules were changed")
+	default:
+		fmt.Println("Migrated rules")
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"github.com/zekker6/external-dns-adguard-provider/adguardhome"
+)
+
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to a YAML or JSON configuration file")
+	instance := fs.String("instance", "", "Name of the AdguardHome instance, the primary instance by default")
+	snapshot := fs.String("snapshot", "", "Path or file name of the snapshot to restore")
+	list := fs.Bool("list", false, "List snapshots of the instance instead of restoring one")
+	dryRun := fs.Bool("dry-run", false, "Log the changes without saving them")
+	_ = fs.Parse(args)
+
+	cfg, err := loadCommandConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	cfg.DryRun = cfg.DryRun || *dryRun
+	if cfg.Backup.Dir == "" {
+		return errors.New("backup directory is not configured")
+	}
+	if *instance == "" {
+		*instance = cfg.InstanceNames()[0]
+	}
+
+	snapshots, err := adguardhome.ListBackups(cfg.Backup.Dir, *instance)
+	if err != nil {
+		return err
+	}
+
+	if *list {
+		for _, s := range snapshots {
+			fmt.Println(s)
+		}
+		return nil
+	}
+
+	path, err := findSnapshot(snapshots, *snapshot)
+	if err != nil {
+		return err
+	}
+	rules, err := adguardhome.ReadBackup(path)
+	if err != nil {
+		return err
+	}
+
+	p, err := adguardhome.NewAdguardHomeProvider(cfg)
+	if err != nil {
+		return err
+	}
+	if err := p.RestoreRules(context.Background(), *instance, rules); err != nil {
+		return err
+	}
+
+	if cfg.DryRun {
+		fmt.Printf("Dry run, no rules of instance %s were restored from %s\n", *instance, path)
+		return nil
+	}
+	fmt.Printf("Restored %d rules of instance %s from %s\n", len(rules), *instance, path)
+	return nil
+}
+
+// findSnapshot returns the snapshot matching the path or the file name given by the user
+func findSnapshot(snapshots []string, name string) (string, error) {
+	if name == "" {
+		return "", errors.New("snapshot is required, use -list to list snapshots")
+	}
+
+	for _, s := range snapshots {
+		if s == name || filepath.Base(s) == name {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("snapshot %s not found, use -list to list snapshots", name)
+}
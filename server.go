@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"sigs.k8s.io/external-dns/plan"
+	"sigs.k8s.io/external-dns/provider"
+	"sigs.k8s.io/external-dns/provider/webhook/api"
+
+	"github.com/zekker6/external-dns-adguard-provider/adguardhome"
+)
+
+// readinessProvider is a provider reporting whether its backend can be used.
+type readinessProvider interface {
+	provider.Provider
+	// Ready returns the reason the provider is degraded, nil when it is ready
+	Ready() error
+}
+
+// newServer returns a server exposing the ExternalDNS webhook API of the provider.
+// The routes match api.StartHTTPApi, which doesn't support TLS. Records are served by recordsHandler
+// since api.WebhookServer doesn't pass the request context to the provider.
+func newServer(p readinessProvider, cfg adguardhome.ServerConfig) (*http.Server, error) {
+	ws := api.WebhookServer{
+		Provider: p,
+	}
+
+	// With mutual TLS, probes and metrics scrapers may connect without a client certificate
+	webhook := func(h http.HandlerFunc) http.Handler { return h }
+	if cfg.TLS.ClientCAFile != "" {
+		webhook = requireClientCert
+	}
+
+	m := http.NewServeMux()
+	m.Handle("/", webhook(ws.NegotiateHandler))
+	m.Handle(api.UrlRecords, webhook(recordsHandler(p, cfg.WriteTimeout.Duration)))
+	m.Handle(api.UrlAdjustEndpoints, webhook(ws.AdjustEndpointsHandler))
+	m.Handle("/metrics", promhttp.Handler())
+	m.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	m.HandleFunc("/readyz", readyHandler(p))
+
+	s := &http.Server{
+		Addr:         cfg.ListenAddress,
+		Handler:      m,
+		ReadTimeout:  cfg.ReadTimeout.Duration,
+		WriteTimeout: cfg.WriteTimeout.Duration,
+	}
+
+	if cfg.TLS.CertFile == "" {
+		return s, nil
+	}
+
+	tlsConfig, err := newServerTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	s.TLSConfig = tlsConfig
+
+	return s, nil
+}
+
+// recordsHandler mirrors api.WebhookServer.RecordsHandler, calling the provider with the request context
+// carrying the trace propagated by the caller. The provider is stopped after timeout, when the response
+// can no longer be written.
+func recordsHandler(p provider.Provider, timeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		switch req.Method {
+		case http.MethodGet:
+			records, err := p.Records(ctx)
+			if err != nil {
+				log.Errorf("Failed to get Records: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set(api.ContentTypeHeader, api.MediaTypeFormatAndVersion)
+			w.WriteHeader(http.StatusOK)
+			if err := json.NewEncoder(w).Encode(records); err != nil {
+				log.Errorf("Failed to encode records: %v", err)
+			}
+		case http.MethodPost:
+			var changes plan.Changes
+			if err := json.NewDecoder(req.Body).Decode(&changes); err != nil {
+				log.Errorf("Failed to decode changes: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if err := p.ApplyChanges(ctx, &changes); err != nil {
+				log.Errorf("Failed to apply changes: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			log.Errorf("Unsupported method %s", req.Method)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}
+}
+
+// readyHandler responds with 503 and the reason while the provider is degraded.
+func readyHandler(p readinessProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		if err := p.Ready(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = fmt.Fprintf(w, "not ready: %s", err)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}
+}
+
+// requireClientCert responds with 401 to requests without a verified client certificate.
+func requireClientCert(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte("client certificate required"))
+			return
+		}
+		h(w, req)
+	})
+}
+
+// newServerTLSConfig returns the TLS configuration of the server. Client certificates are verified when given,
+// routes of the webhook API require them with requireClientCert.
+func newServerTLSConfig(cfg adguardhome.ServerTLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", cfg.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, nil
+}
+
+// serve listens on the server address and serves requests until the server fails.
+func serve(s *http.Server) error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+
+	if s.TLSConfig == nil {
+		log.Infof("AdguardHomeProvider started on %s", s.Addr)
+		return s.Serve(l)
+	}
+
+	log.WithField("mtls", s.TLSConfig.ClientCAs != nil).Infof("AdguardHomeProvider started on %s with TLS", s.Addr)
+	return s.ServeTLS(l, "", "")
+}